@@ -8,7 +8,7 @@ import (
 
 func Index(w http.ResponseWriter, r *http.Request) {
 
-	tmpl := plate.NewTemplate(w)
+	tmpl := plate.NewTemplate(w, r)
 
 	tmpl.Layout = "layout.html"
 	tmpl.Template = "templates/index.html"
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"github.com/ziutek/mymysql/thrsafe"
 	"log"
 	"os"
@@ -24,3 +25,28 @@ func MysqlErrExit(err error) {
 		os.Exit(1)
 	}
 }
+
+// Run calls f, which queries Db, giving up with the context's error
+// if it is done first, ie once the deadline of the request being
+// served passes:
+//
+//	err := database.Run(r.Context(), func() error {
+//		rows, _, err = stmt.Exec(id)
+//		return err
+//	})
+//
+// mymysql can't cancel a query, so f carries on in the background
+// and must not touch anything the caller uses after Run returns
+func Run(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,151 @@
+package plate
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	BasicScheme  = "Basic"
+	BearerScheme = "Bearer"
+	APIKeyScheme = "APIKey"
+)
+
+var (
+	// The credentials were missing or not recognised
+	AuthError = errors.New("Invalid credentials")
+)
+
+// Credentials are presented by a request to an auth middleware
+type Credentials struct {
+	Scheme   string // BasicScheme, BearerScheme or APIKeyScheme
+	Username string // the user, for BasicScheme
+	Secret   string // the password, token or key
+}
+
+// Authenticator checks credentials, returning the principal they
+// identify, ie a user, or an error if they are invalid
+type Authenticator interface {
+	Authenticate(r *http.Request, c Credentials) (interface{}, error)
+}
+
+// AuthenticatorFunc adapts a func to an Authenticator
+type AuthenticatorFunc func(r *http.Request, c Credentials) (interface{}, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request, c Credentials) (interface{}, error) {
+	return f(r, c)
+}
+
+// Principal returns the principal authenticated for the request,
+// or nil. Templates made with Server.Template(w, r) call it as
+// {{principal}}
+func Principal(r *http.Request) interface{} {
+	return r.Context().Value(principalKey)
+}
+
+// BasicAuth returns middleware requiring HTTP Basic credentials,
+// answering a 401 asking for them in realm otherwise
+func BasicAuth(realm string, auth Authenticator) Middleware {
+	challenge := BasicScheme + ` realm="` + strings.Replace(realm, `"`, `'`, -1) + `"`
+	return authenticate(auth, challenge, func(r *http.Request) (Credentials, bool) {
+		username, password, ok := r.BasicAuth()
+		return Credentials{Scheme: BasicScheme, Username: username, Secret: password}, ok
+	})
+}
+
+// BearerAuth returns middleware requiring a bearer token
+// in the Authorization header
+func BearerAuth(auth Authenticator) Middleware {
+	return authenticate(auth, BearerScheme, func(r *http.Request) (Credentials, bool) {
+		h := r.Header.Get("Authorization")
+		if len(h) <= len(BearerScheme)+1 || !strings.EqualFold(h[:len(BearerScheme)+1], BearerScheme+" ") {
+			return Credentials{}, false
+		}
+		return Credentials{Scheme: BearerScheme, Secret: strings.TrimSpace(h[len(BearerScheme)+1:])}, true
+	})
+}
+
+// APIKeyAuth returns middleware requiring an API key, sent in the
+// header or the query param. Either may be "" to not accept it
+func APIKeyAuth(header, query string, auth Authenticator) Middleware {
+	return authenticate(auth, "", func(r *http.Request) (Credentials, bool) {
+		key := ""
+		if len(header) > 0 {
+			key = r.Header.Get(header)
+		}
+		if len(key) == 0 && len(query) > 0 {
+			key = r.URL.Query().Get(query)
+		}
+		return Credentials{Scheme: APIKeyScheme, Secret: key}, len(key) > 0
+	})
+}
+
+// authenticate builds the middleware, reading credentials with
+// read and answering a 401 with the challenge when they are
+// missing or rejected
+func authenticate(auth Authenticator, challenge string, read func(*http.Request) (Credentials, bool)) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var principal interface{}
+			c, ok := read(r)
+			if ok {
+				var err error
+				if principal, err = auth.Authenticate(r, c); err != nil {
+					principal = nil
+				}
+			}
+			if principal == nil {
+				if len(challenge) > 0 {
+					w.Header().Set("WWW-Authenticate", challenge)
+				}
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, principal)))
+		})
+	}
+}
+
+// BasicUsers authenticates Basic credentials against a map of
+// username to password, returning the username as principal.
+// Every entry is compared in constant time, so the time taken
+// doesn't reveal whether a username exists
+func BasicUsers(users map[string]string) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request, c Credentials) (interface{}, error) {
+		user, pass := sha256.Sum256([]byte(c.Username)), sha256.Sum256([]byte(c.Secret))
+		found := ""
+		for username, password := range users {
+			u, p := sha256.Sum256([]byte(username)), sha256.Sum256([]byte(password))
+			if subtle.ConstantTimeCompare(user[:], u[:])&subtle.ConstantTimeCompare(pass[:], p[:]) == 1 {
+				found = username
+			}
+		}
+		if len(found) == 0 {
+			return nil, AuthError
+		}
+		return found, nil
+	})
+}
+
+// Tokens authenticates bearer tokens or API keys against a map
+// of secret to principal, comparing in constant time
+func Tokens(tokens map[string]interface{}) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request, c Credentials) (interface{}, error) {
+		secret := sha256.Sum256([]byte(c.Secret))
+		var found interface{}
+		for token, principal := range tokens {
+			t := sha256.Sum256([]byte(token))
+			if subtle.ConstantTimeCompare(secret[:], t[:]) == 1 {
+				found = principal
+			}
+		}
+		if found == nil {
+			return nil, AuthError
+		}
+		return found, nil
+	})
+}
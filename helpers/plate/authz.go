@@ -0,0 +1,140 @@
+package plate
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Authorizer decides whether the principal of a request, see
+// Principal, meets every one of the requirements of a route,
+// ie roles or permissions
+type Authorizer interface {
+	Authorize(r *http.Request, principal interface{}, requirements []string) bool
+}
+
+// AuthorizerFunc adapts a func to an Authorizer
+type AuthorizerFunc func(r *http.Request, principal interface{}, requirements []string) bool
+
+func (f AuthorizerFunc) Authorize(r *http.Request, principal interface{}, requirements []string) bool {
+	return f(r, principal, requirements)
+}
+
+// DenyHook is told about every request denied by Require, ie to
+// record it in an audit log
+type DenyHook func(r *http.Request, principal interface{}, requirements []string)
+
+// RoleAuthorizer is the default Authorizer. It takes the roles from
+// the principal when it has a Roles() []string method, and otherwise
+// from the []string stored under SessionKey in the session
+type RoleAuthorizer struct {
+	Sessions   *RequestSessions // defaults to Session
+	SessionKey string           // defaults to "roles"
+}
+
+func (this RoleAuthorizer) Authorize(r *http.Request, principal interface{}, requirements []string) bool {
+	var roles []string
+	if p, ok := principal.(interface {
+		Roles() []string
+	}); ok {
+		roles = p.Roles()
+	} else {
+		sessions, key := this.Sessions, this.SessionKey
+		if sessions == nil {
+			sessions = Session
+		}
+		if len(key) == 0 {
+			key = "roles"
+		}
+		roles, _ = sessions.Get(r)[key].([]string)
+	}
+
+	for _, required := range requirements {
+		found := false
+		for _, role := range roles {
+			if role == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Add a hook told about every request denied by Require
+func (this *Server) OnDeny(hook DenyHook) {
+	this.denyHooks = append(this.denyHooks, hook)
+}
+
+// Require returns middleware allowing only requests whose principal
+// meets every requirement, as decided by the server's Authorizer.
+// Others are answered with a 403, or for HTML routes requested by a
+// browser redirected to the LoginRoute, when set.
+func (this *Server) Require(requirements ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var authorizer Authorizer = RoleAuthorizer{}
+			if this.Authorizer != nil {
+				authorizer = this.Authorizer
+			}
+
+			principal := Principal(r)
+			if authorizer.Authorize(r, principal, requirements) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			this.deny(w, r, principal, requirements)
+		})
+	}
+}
+
+// Require restricts the route to requests whose principal meets
+// every requirement, see Server.Require. Middleware authenticating
+// the principal must be added to the route first
+func (this *Route) Require(requirements ...string) *Route {
+	if this.server == nil {
+		return this
+	}
+	return this.Use(this.server.Require(requirements...))
+}
+
+// Require restricts every route of the group and of its nested
+// groups, whether created before or after the call, see
+// Server.Require
+func (this *Group) Require(requirements ...string) {
+	for _, route := range this.routes {
+		route.Require(requirements...)
+	}
+	for _, group := range this.groups {
+		group.Require(requirements...)
+	}
+	this.Use(this.server.Require(requirements...))
+}
+
+// deny records the denial and answers the request
+func (this *Server) deny(w http.ResponseWriter, r *http.Request, principal interface{}, requirements []string) {
+	this.Logger.Printf("denied %s %s to %v, requires %s (request %s)", r.Method, r.URL.Path,
+		principal, strings.Join(requirements, ", "), RequestID(r))
+	for _, hook := range this.denyHooks {
+		hook(r, principal, requirements)
+	}
+
+	if len(this.LoginRoute) > 0 && r.Method == GET && wantsHtml(r) {
+		if login, err := this.URL(this.LoginRoute); err == nil {
+			http.Redirect(w, r, login+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
+
+// wantsHtml reports whether the request is for an HTML route and
+// came from a browser, rather than a script
+func wantsHtml(r *http.Request) bool {
+	route := matched(r)
+	return route != nil && strings.HasPrefix(route.contenttype, "text/html") &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
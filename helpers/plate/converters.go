@@ -0,0 +1,154 @@
+package plate
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// layout of `<date>` route params
+	DateLayout = "2006-01-02"
+)
+
+// Converter validates a route param and converts it to a typed
+// value, selected by name in the pattern, ie ‘/user/:id<int>’.
+// When Convert fails the route does not match and the request
+// is answered with a 404.
+type Converter struct {
+	Expr    string                            // regular expression a value must match
+	Convert func(string) (interface{}, error) // converts a matched value
+	Format  func(interface{}) string          // formats a value for URL, optional
+}
+
+// UUID is the value of a `<uuid>` route param
+type UUID [16]byte
+
+var (
+	// the converters available to every server
+	converters = map[string]*Converter{
+		"int": {
+			Expr: `-?[0-9]+`,
+			Convert: func(s string) (interface{}, error) {
+				return strconv.Atoi(s)
+			},
+		},
+		"slug": {
+			Expr: `[a-z0-9]+(?:[-_][a-z0-9]+)*`,
+			Convert: func(s string) (interface{}, error) {
+				return s, nil
+			},
+		},
+		"uuid": {
+			Expr: `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+			Convert: func(s string) (interface{}, error) {
+				return ParseUUID(s)
+			},
+		},
+		"date": {
+			Expr: `[0-9]{4}-[0-9]{2}-[0-9]{2}`,
+			Convert: func(s string) (interface{}, error) {
+				return time.Parse(DateLayout, s)
+			},
+			Format: func(v interface{}) string {
+				if t, ok := v.(time.Time); ok {
+					return t.Format(DateLayout)
+				}
+				return fmt.Sprint(v)
+			},
+		},
+	}
+
+	UUIDError = errors.New("Invalid UUID")
+)
+
+// Registers a converter for use in route patterns added from now on,
+// overriding any built in converter of the same name
+func (this *Server) AddConverter(name string, conv *Converter) {
+	if this.converters == nil {
+		this.converters = map[string]*Converter{}
+	}
+	this.converters[name] = conv
+}
+
+func (this *Server) converter(name string) (*Converter, bool) {
+	if conv, ok := this.converters[name]; ok {
+		return conv, true
+	}
+	conv, ok := converters[name]
+	return conv, ok
+}
+
+// ParamValue returns the converted value of the route param
+// name, or its raw string when the param has no converter.
+func ParamValue(r *http.Request, name string) interface{} {
+	name = strings.TrimPrefix(name, ":")
+	if values, ok := r.Context().Value(valuesKey).(map[string]interface{}); ok {
+		if v, ok := values[name]; ok {
+			return v
+		}
+	}
+	return Param(r, name)
+}
+
+// ParamInt returns the value of an `<int>` route param,
+// or zero if it is missing or not a number.
+func ParamInt(r *http.Request, name string) int {
+	switch v := ParamValue(r, name).(type) {
+	case int:
+		return v
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	}
+	return 0
+}
+
+// ParamUUID returns the value of a `<uuid>` route param,
+// or the zero UUID if it is missing or malformed.
+func ParamUUID(r *http.Request, name string) UUID {
+	switch v := ParamValue(r, name).(type) {
+	case UUID:
+		return v
+	case string:
+		u, _ := ParseUUID(v)
+		return u
+	}
+	return UUID{}
+}
+
+// ParamDate returns the value of a `<date>` route param,
+// or the zero time if it is missing or malformed.
+func ParamDate(r *http.Request, name string) time.Time {
+	switch v := ParamValue(r, name).(type) {
+	case time.Time:
+		return v
+	case string:
+		t, _ := time.Parse(DateLayout, v)
+		return t
+	}
+	return time.Time{}
+}
+
+// ParseUUID parses the canonical 8-4-4-4-12 hex form of a UUID
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, UUIDError
+	}
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil {
+		return u, UUIDError
+	}
+	copy(u[:], b)
+	return u, nil
+}
+
+func (u UUID) String() string {
+	s := hex.EncodeToString(u[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
@@ -0,0 +1,174 @@
+package plate
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CorsOptions configures the Cors middleware
+type CorsOptions struct {
+	Origins        []string      // allowed origins, ie "https://example.com", "https://*.example.com" or "*" for any
+	Methods        []string      // allowed methods, defaults to GET, HEAD and POST
+	Headers        []string      // allowed request headers, "*" for any
+	ExposedHeaders []string      // response headers scripts may read
+	Credentials    bool          // allow cookies and authorization headers
+	MaxAge         time.Duration // how long preflight results may be cached
+}
+
+// Cors returns middleware implementing cross-origin resource sharing.
+// Preflight requests are answered by the middleware and never reach
+// the route's handler; requests from origins that aren't allowed are
+// served without CORS headers, so browsers will refuse the response.
+// Credentials can only be allowed for listed origins, not "*".
+//
+//	server.Use(plate.Cors(plate.CorsOptions{
+//		Origins: []string{"https://example.com", "https://*.example.com"},
+//		Methods: []string{plate.GET, plate.POST},
+//	}))
+func Cors(options CorsOptions) Middleware {
+	if options.any() && options.Credentials {
+		// echoing any origin with credentials would let every
+		// site read responses made with the user's cookies
+		panic(errors.New("Cors can't allow credentials from any origin, list the origins instead"))
+	}
+	if len(options.Methods) == 0 {
+		options.Methods = []string{GET, HEAD, POST}
+	}
+	methods := strings.Join(options.Methods, ", ")
+	exposed := strings.Join(options.ExposedHeaders, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == OPTIONS && len(r.Header.Get("Access-Control-Request-Method")) > 0
+
+			// the response depends on Origin unless every origin gets "*"
+			if !options.any() {
+				w.Header().Add("Vary", "Origin")
+			}
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if len(origin) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !options.allowOrigin(origin) {
+				if preflight {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if options.any() {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if options.Credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if len(exposed) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			headers := r.Header.Get("Access-Control-Request-Headers")
+			if !options.allowMethod(method) || !options.allowHeaders(headers) {
+				w.Header().Del("Access-Control-Allow-Origin")
+				w.Header().Del("Access-Control-Allow-Credentials")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if len(headers) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			if options.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(options.MaxAge/time.Second)))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// any reports whether every origin is allowed
+func (this CorsOptions) any() bool {
+	for _, allowed := range this.Origins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin matches origin against the allowed origins, where a
+// "*" in an origin matches one or more subdomains
+func (this CorsOptions) allowOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range this.Origins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if i := strings.Index(allowed, "*"); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (this CorsOptions) allowMethod(method string) bool {
+	for _, allowed := range this.Methods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders reports whether every header in the comma separated
+// list is allowed. Simple headers don't need to be listed
+func (this CorsOptions) allowHeaders(headers string) bool {
+	for _, header := range strings.Split(headers, ",") {
+		header = strings.TrimSpace(header)
+		if len(header) == 0 || corsSimpleHeaders[strings.ToLower(header)] {
+			continue
+		}
+		ok := false
+		for _, allowed := range this.Headers {
+			if allowed == "*" || strings.EqualFold(allowed, header) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+var corsSimpleHeaders = map[string]bool{
+	"accept":           true,
+	"accept-language":  true,
+	"content-language": true,
+	"content-type":     true,
+}
@@ -0,0 +1,92 @@
+package plate
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+const (
+	CSRFFieldName = "csrf_token"   // form field checked for the CSRF token
+	CSRFHeader    = "X-CSRF-Token" // header checked for the CSRF token, ie by scripts
+
+	csrfSessionKey = "_csrf"
+)
+
+// CSRF returns middleware protecting against cross-site request
+// forgery. Each session is given a random token, which requests with
+// unsafe methods must send back in the CSRFFieldName form field or the
+// CSRFHeader header, or be answered with a 403. The server must be
+// served through a SessionHandler using sessions, ie:
+//
+//	server.Use(plate.CSRF(plate.Session))
+//	http.Handle("/", server.SessionHandler)
+//
+// Templates made with Server.Template(w, r) add the token to forms
+// with {{csrfField}}. Routes called by other sites or non browser
+// clients opt out with Route.NoCSRF.
+func CSRF(sessions *RequestSessions) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route := matched(r); route != nil && route.nocsrf {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			session := sessions.Get(r)
+			if session == nil {
+				log.Printf("csrf: no session for %s %s, is the server wrapped by a SessionHandler?", r.Method, r.URL.Path)
+			}
+
+			token, _ := session[csrfSessionKey].(string)
+			if len(token) == 0 && session != nil {
+				b := make([]byte, 32)
+				if _, err := rand.Read(b); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				token = base64.RawURLEncoding.EncodeToString(b)
+				session[csrfSessionKey] = token
+			}
+
+			switch r.Method {
+			case GET, HEAD, OPTIONS, TRACE:
+			default:
+				sent := r.Header.Get(CSRFHeader)
+				if len(sent) == 0 {
+					sent = r.PostFormValue(CSRFFieldName)
+				}
+				if len(token) == 0 || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					http.Error(w, "Forbidden - invalid CSRF token", http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey, token)))
+		})
+	}
+}
+
+// Excludes the route from CSRF checks, ie for JSON APIs
+// authenticated by a header rather than a cookie
+func (this *Route) NoCSRF() *Route {
+	this.nocsrf = true
+	return this
+}
+
+// CSRFToken returns the CSRF token of the request's session,
+// or "" if the request didn't pass through the CSRF middleware
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey).(string)
+	return token
+}
+
+// CSRFField returns a hidden form input holding the CSRF token
+func CSRFField(r *http.Request) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + CSRFFieldName + `" value="` +
+		template.HTMLEscapeString(CSRFToken(r)) + `">`)
+}
@@ -0,0 +1,139 @@
+package plate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETags returns middleware that buffers successful responses to GET
+// and HEAD requests, tags them with an ETag of their content, weak or
+// strong, unless the handler set one, and answers requests whose
+// If-None-Match or If-Modified-Since shows the client has the
+// response already with a 304, ie:
+//
+//	api.Use(plate.ETags(false))
+//
+// Buffering means the response is only sent once complete, so it
+// is not suited to streaming or very large responses.
+func ETags(weak bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != GET && r.Method != HEAD {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ew := &etagWriter{writer: w}
+			next.ServeHTTP(ew, r)
+			if ew.status == 0 {
+				// nothing was written, leave the request unanswered
+				return
+			}
+			if ew.status != http.StatusOK {
+				w.WriteHeader(ew.status)
+				w.Write(ew.body.Bytes())
+				return
+			}
+
+			etag := w.Header().Get("ETag")
+			if len(etag) == 0 {
+				sum := sha256.Sum256(ew.body.Bytes())
+				etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+				if weak {
+					etag = "W/" + etag
+				}
+				w.Header().Set("ETag", etag)
+			}
+
+			if notModified(r, etag, w.Header().Get("Last-Modified")) {
+				writeNotModified(w)
+				return
+			}
+			w.WriteHeader(ew.status)
+			w.Write(ew.body.Bytes())
+		})
+	}
+}
+
+// CheckETag tags the response with an ETag made from version, ie a
+// revision number or updated timestamp, and answers with a 304 if
+// the client has that version already. Handlers call it before doing
+// the work of building the response, and return if it reports true:
+//
+//	if plate.CheckETag(w, r, strconv.Itoa(post.Revision)) {
+//		return
+//	}
+func CheckETag(w http.ResponseWriter, r *http.Request, version string) bool {
+	etag := `W/"` + strings.Replace(version, `"`, "", -1) + `"`
+	w.Header().Set("ETag", etag)
+	if (r.Method == GET || r.Method == HEAD) && notModified(r, etag, "") {
+		writeNotModified(w)
+		return true
+	}
+	return false
+}
+
+// notModified reports whether the conditional headers of the request
+// match the etag or last modified time of the response. If-None-Match
+// takes precedence over If-Modified-Since
+func notModified(r *http.Request, etag, lastModified string) bool {
+	if inm := r.Header.Get("If-None-Match"); len(inm) > 0 {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || opaqueTag(tag) == opaqueTag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(ims)
+}
+
+// opaqueTag strips the weak prefix, as GET compares ETags weakly
+func opaqueTag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// etagWriter buffers the response, so its ETag can be computed
+type etagWriter struct {
+	writer http.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (this *etagWriter) Header() http.Header {
+	return this.writer.Header()
+}
+
+func (this *etagWriter) Write(p []byte) (int, error) {
+	if this.status == 0 {
+		this.status = http.StatusOK
+	}
+	return this.body.Write(p)
+}
+
+func (this *etagWriter) WriteHeader(code int) {
+	if this.status == 0 {
+		this.status = code
+	}
+}
@@ -0,0 +1,94 @@
+package plate
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Group registers routes on a Server under a shared prefix,
+// attaching the group's filters to every route it creates.
+type Group struct {
+	server     *Server
+	host       string
+	prefix     string
+	filters    []http.HandlerFunc
+	middleware []Middleware
+	routes     []*Route
+	groups     []*Group
+}
+
+// Creates a Group whose routes are prefixed with prefix and
+// filtered by filters, in addition to the global filters
+func (this *Server) Group(prefix string, filters ...http.HandlerFunc) *Group {
+	return &Group{
+		server:  this,
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		filters: filters,
+	}
+}
+
+// Creates a nested Group, inheriting this group's prefix, filters
+// and middleware
+func (this *Group) Group(prefix string, filters ...http.HandlerFunc) *Group {
+	group := this.server.Group(this.prefix + prefix)
+	group.host = this.host
+	group.filters = append(append(group.filters, this.filters...), filters...)
+	group.middleware = append(group.middleware, this.middleware...)
+	this.groups = append(this.groups, group)
+	return group
+}
+
+// Add middleware filter to routes created by the group from now on
+func (this *Group) AddFilter(filter http.HandlerFunc) {
+	this.filters = append(this.filters, filter)
+}
+
+// Adds a new Route to the Server, under the group's prefix
+func (this *Group) AddRoute(method string, pattern string, handler http.HandlerFunc) *Route {
+	route := this.server.addRoute(this.host, method, this.path(pattern), handler)
+	for _, filter := range this.filters {
+		route.AddFilter(filter)
+	}
+	if len(this.middleware) > 0 {
+		route.Use(this.middleware...)
+	}
+	this.routes = append(this.routes, route)
+	return route
+}
+
+// Adds a new Route for GET requests
+func (this *Group) Get(pattern string, handler http.HandlerFunc) *Route {
+	return this.AddRoute(GET, pattern, handler)
+}
+
+// Adds a new Route for PUT requests
+func (this *Group) Put(pattern string, handler http.HandlerFunc) *Route {
+	return this.AddRoute(PUT, pattern, handler)
+}
+
+// Adds a new Route for DELETE requests
+func (this *Group) Del(pattern string, handler http.HandlerFunc) *Route {
+	return this.AddRoute(DELETE, pattern, handler)
+}
+
+// Adds a new Route for PATCH requests
+func (this *Group) Patch(pattern string, handler http.HandlerFunc) *Route {
+	return this.AddRoute(PATCH, pattern, handler)
+}
+
+// Adds a new Route for POST requests
+func (this *Group) Post(pattern string, handler http.HandlerFunc) *Route {
+	return this.AddRoute(POST, pattern, handler)
+}
+
+// path joins the group prefix and pattern, so the root of
+// a group "/api" is "/api" rather than "/api/"
+func (this *Group) path(pattern string) string {
+	if pattern == "/" || pattern == "" {
+		if this.prefix == "" {
+			return "/"
+		}
+		return this.prefix
+	}
+	return this.prefix + pattern
+}
@@ -0,0 +1,83 @@
+package plate
+
+import (
+	"net/http"
+	"time"
+)
+
+// Response is the outcome of a request, as captured by the server
+type Response struct {
+	Status   int           // the status code sent, 500 if the handler panicked first
+	Size     int           // bytes written to the body
+	Duration time.Duration // time spent serving the request
+	Panicked bool          // the handler panicked
+}
+
+// AfterFilterFunc runs once the request has been served. The response
+// has usually been written by then, so headers set here are only sent
+// if the handler wrote nothing; use a ResponseHook for those
+type AfterFilterFunc func(w http.ResponseWriter, r *http.Request, res *Response)
+
+// ResponseHook runs just before the response header is written,
+// so it can still set headers based on the status
+type ResponseHook func(w http.ResponseWriter, r *http.Request, status int)
+
+// Add a filter run after every request, even if the handler panics
+func (this *Server) AfterFilter(filter AfterFilterFunc) {
+	this.after = append(this.after, filter)
+}
+
+// Add a hook run before the header of every response is written
+func (this *Server) OnResponse(hook ResponseHook) {
+	this.hooks = append(this.hooks, hook)
+}
+
+// Add a filter run after requests to the route, even if the handler
+// panics. Route after filters run before the global ones
+func (this *Route) AfterFilter(filter AfterFilterFunc) *Route {
+	this.after = append(this.after, filter)
+	return this
+}
+
+// Add a hook run before the header of responses from the route is
+// written. Route hooks run before the global ones
+func (this *Route) OnResponse(hook ResponseHook) *Route {
+	this.hooks = append(this.hooks, hook)
+	return this
+}
+
+// respond runs the route and server hooks for a response
+// with status, about to be written
+func (this *Server) respond(w http.ResponseWriter, r *http.Request, route *Route, status int) {
+	if route != nil {
+		for _, hook := range route.hooks {
+			hook(w, r, status)
+		}
+	}
+	for _, hook := range this.hooks {
+		hook(w, r, status)
+	}
+}
+
+// afterFilter runs the route and server after filters. completed is false
+// when it is called while the handler is panicking
+func (this *Server) afterFilter(w *responseWriter, r *http.Request, route *Route, start time.Time, completed bool) {
+	res := &Response{
+		Status:   w.status,
+		Size:     w.size,
+		Duration: time.Since(start),
+		Panicked: !completed,
+	}
+	if res.Panicked && w.status == 0 {
+		res.Status = http.StatusInternalServerError
+	}
+
+	if route != nil {
+		for _, filter := range route.after {
+			filter(w, r, res)
+		}
+	}
+	for _, filter := range this.after {
+		filter(w, r, res)
+	}
+}
@@ -0,0 +1,116 @@
+package plate
+
+import (
+	"net"
+	"strings"
+)
+
+// hostPattern matches the Host of a request against a pattern
+// such as "api.example.com" or ":tenant.example.com", where each
+// label starting with ":" captures a param.
+type hostPattern struct {
+	pattern string
+	labels  []string
+}
+
+// hostRouter holds the routes scoped to a host pattern
+type hostRouter struct {
+	*router
+	host *hostPattern
+}
+
+// scope is a router to search for a request, along with
+// the params captured from its host
+type scope struct {
+	*router
+	params map[string]string
+}
+
+// Creates a Group whose routes only match requests for host, which
+// may capture labels as params, ie ":tenant.example.com". Requests
+// for a host are matched against its routes first, then against
+// routes registered without a host
+func (this *Server) Host(host string) *Group {
+	return &Group{
+		server: this,
+		host:   host,
+	}
+}
+
+func parseHost(pattern string) *hostPattern {
+	labels := strings.Split(strings.TrimSuffix(pattern, "."), ".")
+	for i, label := range labels {
+		if !strings.HasPrefix(label, ":") {
+			labels[i] = strings.ToLower(label)
+		}
+	}
+	return &hostPattern{pattern: pattern, labels: labels}
+}
+
+// match reports whether host matches the pattern, returning
+// the values of its params
+func (this *hostPattern) match(host string) (map[string]string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(labels) != len(this.labels) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, label := range this.labels {
+		if strings.HasPrefix(label, ":") {
+			if len(labels[i]) == 0 {
+				return nil, false
+			}
+			params[label[1:]] = labels[i]
+			continue
+		}
+		if !strings.EqualFold(label, labels[i]) {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// scope returns the router holding the routes for the host
+// pattern, or the default router when pattern is empty
+func (this *router) scope(pattern string) *router {
+	for _, h := range this.hosts {
+		if h.host.pattern == pattern {
+			return h.router
+		}
+	}
+	if len(pattern) == 0 {
+		return this
+	}
+
+	h := &hostRouter{router: newRouter(nil), host: parseHost(pattern)}
+	this.hosts = append(this.hosts, h)
+	return h.router
+}
+
+// scopes returns the routers to search for a request to host,
+// those scoped to a matching host pattern first, in the order
+// they were registered, and then the default router
+func (this *router) scopes(host string) []scope {
+	var scopes []scope
+	for _, h := range this.hosts {
+		if params, ok := h.host.match(host); ok {
+			scopes = append(scopes, scope{router: h.router, params: params})
+		}
+	}
+	return append(scopes, scope{router: this})
+}
+
+// match finds the route for method that matches host and path,
+// along with the values of its path and host params.
+func (this *router) match(method, host, path string) (*Route, []string, map[string]string) {
+	for _, s := range this.scopes(host) {
+		if route, values := s.lookup(method, path); route != nil {
+			return route, values, s.params
+		}
+	}
+	return nil, nil, nil
+}
@@ -0,0 +1,105 @@
+package plate
+
+import (
+	"../mimetypes"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// RouteInfo is a read-only description of a Route
+type RouteInfo struct {
+	Method      string
+	Host        string
+	Pattern     string
+	Regex       string
+	Params      []string
+	ContentType string
+	Filters     int
+	Name        string
+	Sensitive   bool
+	Unfiltered  bool
+}
+
+var routesTemplate = template.Must(template.New("routes").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Routes</title>
+  <style>
+    body { font-family: "Helvetica Neue", Helvetica, Arial, sans-serif; font-size: 14px; color: #444; }
+    table { border-collapse: collapse; }
+    th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; }
+    code { color: #737373; }
+  </style>
+</head>
+<body>
+  <table>
+    <tr><th>Method</th><th>Host</th><th>Pattern</th><th>Regex</th><th>Params</th><th>Content Type</th><th>Filters</th><th>Name</th></tr>
+    {{range .}}<tr>
+      <td>{{.Method}}</td>
+      <td>{{.Host}}</td>
+      <td>{{.Pattern}}</td>
+      <td><code>{{.Regex}}</code></td>
+      <td>{{range $i, $p := .Params}}{{if $i}}, {{end}}{{$p}}{{end}}</td>
+      <td>{{.ContentType}}</td>
+      <td>{{.Filters}}{{if .Unfiltered}} (no global filters){{end}}</td>
+      <td>{{.Name}}</td>
+    </tr>{{end}}
+  </table>
+</body>
+</html>
+`))
+
+// Info describes the route
+func (this *Route) Info() RouteInfo {
+	info := RouteInfo{
+		Method:      this.method,
+		Host:        this.host,
+		Pattern:     this.pattern,
+		ContentType: this.contenttype,
+		Filters:     len(this.filters),
+		Name:        this.name,
+		Sensitive:   this.sensitive,
+		Unfiltered:  this.unfiltered,
+		Params:      []string{},
+	}
+	if this.regex != nil {
+		info.Regex = this.regex.String()
+	}
+	for _, param := range this.params {
+		if len(param) > 0 {
+			info.Params = append(info.Params, param)
+		}
+	}
+	return info
+}
+
+// RouteTable describes every route, in the order registered
+func (this *Server) RouteTable() []RouteInfo {
+	table := make([]RouteInfo, len(this.Routes))
+	for i, route := range this.Routes {
+		table[i] = route.Info()
+	}
+	return table
+}
+
+// ServeRoutes is a handler that renders the RouteTable, as JSON
+// when requested through the Accept header or ?format=json, and
+// as an HTML table otherwise. ie:
+//
+//	server.Get("/_routes", server.ServeRoutes)
+func (this *Server) ServeRoutes(w http.ResponseWriter, r *http.Request) {
+	table := this.RouteTable()
+
+	if r.URL.Query().Get("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), mimetypes.ApplicationJsonShort) {
+		ServeJson(w, table)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimetypes.TextHtml)
+	if err := routesTemplate.Execute(w, table); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
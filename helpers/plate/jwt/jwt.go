@@ -0,0 +1,354 @@
+// Package jwt signs and verifies JSON Web Tokens using HS256, RS256
+// or ES256, for clients that authenticate without cookies. Tokens
+// are verified by a Keyring, which can hold several keys so signing
+// keys can be rotated while tokens signed by old keys stay valid.
+package jwt
+
+import (
+	".."
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	MalformedError = errors.New("Malformed token")
+	SignatureError = errors.New("Invalid token signature")
+	KeyError       = errors.New("Unknown token key")
+	ExpiredError   = errors.New("Token has expired")
+	NotYetError    = errors.New("Token is not valid yet")
+	IssuerError    = errors.New("Invalid token issuer")
+	AudienceError  = errors.New("Invalid token audience")
+)
+
+// Claims are the registered claims of a token. Embed them
+// in a struct to sign and verify custom claims
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Audience is the aud claim, which is either a string or a list
+type Audience []string
+
+func (this Audience) MarshalJSON() ([]byte, error) {
+	if len(this) == 1 {
+		return json.Marshal(this[0])
+	}
+	return json.Marshal([]string(this))
+}
+
+func (this *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*this = Audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(this))
+}
+
+// Key signs or verifies tokens with one algorithm. Keys made from
+// a public key can only verify
+type Key struct {
+	ID        string // sent as the kid header
+	Algorithm string // HS256, RS256 or ES256
+	secret    []byte
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+// HMACKey returns an HS256 key
+func HMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: HS256, secret: secret}
+}
+
+// RSAKey returns an RS256 key, signing with private
+func RSAKey(id string, private *rsa.PrivateKey) *Key {
+	return &Key{ID: id, Algorithm: RS256, private: private, public: &private.PublicKey}
+}
+
+// RSAPublicKey returns an RS256 key that only verifies
+func RSAPublicKey(id string, public *rsa.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: RS256, public: public}
+}
+
+// ECDSAKey returns an ES256 key, signing with private, which
+// must be on the P-256 curve. Keys on other curves neither sign
+// nor verify, failing with KeyError
+func ECDSAKey(id string, private *ecdsa.PrivateKey) *Key {
+	return &Key{ID: id, Algorithm: ES256, private: private, public: &private.PublicKey}
+}
+
+// ECDSAPublicKey returns an ES256 key that only verifies
+func ECDSAPublicKey(id string, public *ecdsa.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: ES256, public: public}
+}
+
+// SessionKey returns an HS256 key derived from the session key of
+// h, so tokens and cookies are backed by the same secret
+func SessionKey(id string, h *plate.SessionHandler) *Key {
+	return HMACKey(id, h.DeriveKey("jwt"))
+}
+
+// sign returns the signature of the signing input
+func (this *Key) sign(input []byte) ([]byte, error) {
+	sum := sha256.Sum256(input)
+	switch this.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, this.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case RS256:
+		if private, ok := this.private.(*rsa.PrivateKey); ok {
+			return rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, sum[:])
+		}
+	case ES256:
+		if private, ok := this.private.(*ecdsa.PrivateKey); ok && this.p256() {
+			r, s, err := ecdsa.Sign(rand.Reader, private, sum[:])
+			if err != nil {
+				return nil, err
+			}
+			// r and s are fixed size and concatenated, rather than DER
+			sig := make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+			return sig, nil
+		}
+	}
+	return nil, KeyError
+}
+
+// verify reports whether sig is a signature of the signing input
+func (this *Key) verify(input, sig []byte) bool {
+	sum := sha256.Sum256(input)
+	switch this.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, this.secret)
+		mac.Write(input)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case RS256:
+		if public, ok := this.public.(*rsa.PublicKey); ok {
+			return rsa.VerifyPKCS1v15(public, crypto.SHA256, sum[:], sig) == nil
+		}
+	case ES256:
+		if public, ok := this.public.(*ecdsa.PublicKey); ok && this.p256() && len(sig) == 64 {
+			r := new(big.Int).SetBytes(sig[:32])
+			s := new(big.Int).SetBytes(sig[32:])
+			return ecdsa.Verify(public, sum[:], r, s)
+		}
+	}
+	return false
+}
+
+// p256 reports whether the key is on the P-256 curve, the only one
+// ES256 signatures are sized for
+func (this *Key) p256() bool {
+	public, ok := this.public.(*ecdsa.PublicKey)
+	return ok && public.Curve == elliptic.P256()
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Keyring signs tokens with its current key and verifies them with
+// any of its keys, chosen by the kid header
+type Keyring struct {
+	Issuer   string        // set by Issue, and required of verified tokens when not empty
+	Audience string        // set by Issue, and required of verified tokens when not empty
+	Leeway   time.Duration // allowed clock skew when checking exp and nbf
+	lock     sync.RWMutex
+	current  *Key
+	keys     map[string]*Key
+}
+
+// NewKeyring returns a Keyring signing with key
+func NewKeyring(key *Key) *Keyring {
+	this := &Keyring{keys: map[string]*Key{}}
+	this.Use(key)
+	return this
+}
+
+// Add adds a key used only to verify tokens, ie a previous
+// signing key, or a public key of another issuer
+func (this *Keyring) Add(key *Key) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.keys[key.ID] = key
+}
+
+// Use adds key and signs tokens with it from now on. The previous
+// key still verifies tokens until it is removed
+func (this *Keyring) Use(key *Key) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.keys[key.ID] = key
+	this.current = key
+}
+
+// Remove stops verifying tokens signed by the key id
+func (this *Keyring) Remove(id string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.keys, id)
+}
+
+// Issue signs a token for subject, expiring after ttl
+func (this *Keyring) Issue(subject string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		Issuer:    this.Issuer,
+		Subject:   subject,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	if len(this.Audience) > 0 {
+		claims.Audience = Audience{this.Audience}
+	}
+	return this.Sign(claims)
+}
+
+// Sign signs the claims, any value marshalling to a JSON object,
+// with the current key
+func (this *Keyring) Sign(claims interface{}) (string, error) {
+	this.lock.RLock()
+	key := this.current
+	this.lock.RUnlock()
+	if key == nil {
+		return "", KeyError
+	}
+
+	h, err := json.Marshal(header{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := encode(h) + "." + encode(payload)
+	sig, err := key.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + encode(sig), nil
+}
+
+// Verify checks the token's signature and its exp, nbf, iss and aud
+// claims, then decodes the claims into v, if not nil. It returns the
+// registered claims
+func (this *Keyring) Verify(token string, v interface{}) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, MalformedError
+	}
+
+	var h header
+	if err := decode(parts[0], &h); err != nil {
+		return nil, MalformedError
+	}
+
+	this.lock.RLock()
+	key, ok := this.keys[h.KeyID]
+	this.lock.RUnlock()
+	// the algorithm is the key's, never the token's choice
+	if !ok || h.Algorithm != key.Algorithm || (key.Algorithm == ES256 && !key.p256()) {
+		return nil, KeyError
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, MalformedError
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return nil, SignatureError
+	}
+
+	claims := &Claims{}
+	if err := decode(parts[1], claims); err != nil {
+		return nil, MalformedError
+	}
+	if err := this.validate(claims); err != nil {
+		return nil, err
+	}
+	if v != nil {
+		if err := decode(parts[1], v); err != nil {
+			return nil, MalformedError
+		}
+	}
+	return claims, nil
+}
+
+func (this *Keyring) validate(claims *Claims) error {
+	now := time.Now()
+	if claims.ExpiresAt != 0 && now.Add(-this.Leeway).After(time.Unix(claims.ExpiresAt, 0)) {
+		return ExpiredError
+	}
+	if claims.NotBefore != 0 && now.Add(this.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return NotYetError
+	}
+	if len(this.Issuer) > 0 && claims.Issuer != this.Issuer {
+		return IssuerError
+	}
+	if len(this.Audience) > 0 {
+		for _, aud := range claims.Audience {
+			if aud == this.Audience {
+				return nil
+			}
+		}
+		return AudienceError
+	}
+	return nil
+}
+
+// Authenticate verifies bearer tokens, making the keyring a
+// plate.Authenticator whose principal is the token's *Claims, ie:
+//
+//	route.Use(plate.BearerAuth(keyring))
+func (this *Keyring) Authenticate(r *http.Request, c plate.Credentials) (interface{}, error) {
+	if c.Scheme != plate.BearerScheme {
+		return nil, plate.AuthError
+	}
+	return this.Verify(c.Secret, nil)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
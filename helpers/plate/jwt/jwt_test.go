@@ -0,0 +1,149 @@
+package jwt
+
+import (
+	".."
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type custom struct {
+		Claims
+		Role string `json:"role"`
+	}
+
+	for _, key := range []*Key{HMACKey("h", []byte("secret")), RSAKey("r", rsaKey), ECDSAKey("e", ecKey)} {
+		keyring := NewKeyring(key)
+		token, err := keyring.Sign(custom{Claims{Subject: "ann"}, "admin"})
+		if err != nil {
+			t.Fatalf("%s: %s", key.Algorithm, err)
+		}
+
+		var v custom
+		claims, err := keyring.Verify(token, &v)
+		if err != nil || claims.Subject != "ann" || v.Role != "admin" {
+			t.Errorf("%s: claims expected, got: %v %+v %+v", key.Algorithm, err, claims, v)
+		}
+
+		parts := strings.Split(token, ".")
+		forged := parts[0] + "." + encode([]byte(`{"sub":"bob"}`)) + "." + parts[2]
+		if _, err := keyring.Verify(forged, nil); err != SignatureError {
+			t.Errorf("%s: SignatureError expected, got: %v", key.Algorithm, err)
+		}
+	}
+
+	// a public key only verifies
+	verifier := NewKeyring(RSAPublicKey("r", &rsaKey.PublicKey))
+	token, _ := NewKeyring(RSAKey("r", rsaKey)).Issue("ann", time.Minute)
+	if _, err := verifier.Verify(token, nil); err != nil {
+		t.Errorf("public key expected to verify, got: %v", err)
+	}
+	if _, err := verifier.Sign(Claims{}); err != KeyError {
+		t.Errorf("KeyError expected signing with a public key, got: %v", err)
+	}
+
+	// the token can't pick a weaker algorithm than its key's
+	none := encode([]byte(`{"alg":"HS256","kid":"r"}`)) + "." + encode([]byte(`{}`)) + "."
+	if _, err := verifier.Verify(none, nil); err != KeyError {
+		t.Errorf("KeyError expected for a mismatched algorithm, got: %v", err)
+	}
+
+	// ES256 keys must be on P-256
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := NewKeyring(ECDSAKey("p", p384))
+	if _, err := keyring.Issue("ann", time.Minute); err != KeyError {
+		t.Errorf("KeyError expected signing with a P-384 key, got: %v", err)
+	}
+	forged := encode([]byte(`{"alg":"ES256","kid":"p"}`)) + "." + encode([]byte(`{}`)) + "." + encode(make([]byte, 64))
+	if _, err := keyring.Verify(forged, nil); err != KeyError {
+		t.Errorf("KeyError expected verifying with a P-384 key, got: %v", err)
+	}
+}
+
+func TestClaims(t *testing.T) {
+	keyring := NewKeyring(HMACKey("k", []byte("secret")))
+	keyring.Issuer = "plate"
+	keyring.Audience = "mobile"
+	now := time.Now()
+
+	tests := []struct {
+		claims Claims
+		err    error
+	}{
+		{Claims{Issuer: "plate", Audience: Audience{"web", "mobile"}, ExpiresAt: now.Add(time.Minute).Unix()}, nil},
+		{Claims{Issuer: "plate", Audience: Audience{"mobile"}, ExpiresAt: now.Add(-time.Minute).Unix()}, ExpiredError},
+		{Claims{Issuer: "plate", Audience: Audience{"mobile"}, NotBefore: now.Add(time.Minute).Unix()}, NotYetError},
+		{Claims{Issuer: "other", Audience: Audience{"mobile"}}, IssuerError},
+		{Claims{Issuer: "plate", Audience: Audience{"web"}}, AudienceError},
+	}
+	for i, test := range tests {
+		token, _ := keyring.Sign(test.claims)
+		if _, err := keyring.Verify(token, nil); err != test.err {
+			t.Errorf("%d: %v expected, got: %v", i, test.err, err)
+		}
+	}
+
+	keyring.Leeway = 2 * time.Minute
+	token, _ := keyring.Sign(tests[1].claims)
+	if _, err := keyring.Verify(token, nil); err != nil {
+		t.Errorf("expiry within leeway expected to be accepted, got: %v", err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	keyring := NewKeyring(HMACKey("2023", []byte("old")))
+	old, _ := keyring.Issue("ann", time.Minute)
+
+	keyring.Use(HMACKey("2024", []byte("new")))
+	token, _ := keyring.Issue("ann", time.Minute)
+	if !strings.HasPrefix(token, encode([]byte(`{"alg":"HS256","typ":"JWT","kid":"2024"}`))) {
+		t.Errorf("new key expected to sign, got: %s", token)
+	}
+	if _, err := keyring.Verify(old, nil); err != nil {
+		t.Errorf("old token expected to verify until its key is removed, got: %v", err)
+	}
+
+	keyring.Remove("2023")
+	if _, err := keyring.Verify(old, nil); err != KeyError {
+		t.Errorf("KeyError expected after removing the key, got: %v", err)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	server := plate.NewServer("secret")
+	server.Logging = false
+	keyring := NewKeyring(SessionKey("session", server.SessionHandler))
+	server.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(plate.Principal(r).(*Claims).Subject))
+	}).Use(plate.BearerAuth(keyring))
+
+	token, _ := keyring.Issue("ann", time.Minute)
+	for auth, expected := range map[string]int{"Bearer " + token: 200, "Bearer " + token + "x": 401, "": 401} {
+		r := httptest.NewRequest(plate.GET, "/me", nil)
+		r.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != expected || (expected == 200 && w.Body.String() != "ann") {
+			t.Errorf("%d expected, got: %d '%s'", expected, w.Code, w.Body.String())
+		}
+	}
+}
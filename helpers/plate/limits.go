@@ -0,0 +1,156 @@
+package plate
+
+import (
+	"../mimetypes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// The request body is larger than the limit of the route
+	BodyLimitError = errors.New("Request body too large")
+)
+
+// BodyLimit is the error sent with a 413 when a request body
+// exceeds the limit
+type BodyLimit struct {
+	Error string `json:"error"`
+	Limit int64  `json:"limit"`
+}
+
+// Limits the request body of the route to n bytes, overriding the
+// server's MaxBody. A negative n removes the limit
+func (this *Route) MaxBody(n int64) *Route {
+	this.maxBody = n
+	return this
+}
+
+// bodyLimit returns the body limit of the route, or 0 for none
+func (this *Server) bodyLimit(route *Route) int64 {
+	if route.maxBody != 0 {
+		if route.maxBody < 0 {
+			return 0
+		}
+		return route.maxBody
+	}
+	return this.MaxBody
+}
+
+// limitBody enforces the route's body limit, answering with a 413
+// when the declared length is too large. It reports false if the
+// request was answered. Otherwise the body is replaced by a reader
+// that fails with BodyLimitError once more than the limit is read,
+// after which w drops whatever the handler writes, and overLimit
+// answers with the 413 once the handler returns. The BodyTimeout
+// only covers reading the body: its deadline is cleared once the
+// body is read, so it can't cancel the request afterwards
+func (this *Server) limitBody(w *responseWriter, r *http.Request, route *Route, rw http.ResponseWriter) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	limit := this.bodyLimit(route)
+	if limit > 0 && r.ContentLength > limit {
+		serveBodyLimit(w, limit)
+		return false
+	}
+
+	body := &limitedBody{ReadCloser: r.Body, limit: limit, remaining: limit}
+	if this.BodyTimeout > 0 {
+		// not every ResponseWriter supports deadlines, ie in tests
+		controller := http.NewResponseController(rw)
+		if controller.SetReadDeadline(time.Now().Add(this.BodyTimeout)) == nil {
+			body.read = func() { controller.SetReadDeadline(time.Time{}) }
+		}
+	}
+	if limit <= 0 && body.read == nil {
+		return true
+	}
+	w.body = body
+	r.Body = w.body
+	return true
+}
+
+// overLimit answers with the 413 if the handler read past the body
+// limit without having started its response first
+func (this *Server) overLimit(w *responseWriter) {
+	if w.body == nil || !w.body.exceeded() || w.started {
+		return
+	}
+	limit := w.body.limit
+	w.body = nil
+
+	// the handler may have set headers for the response
+	// it was going to write, ie Content-Encoding by gzip
+	w.Header().Del("Content-Encoding")
+	w.Header().Del("Content-Length")
+	serveBodyLimit(w, limit)
+}
+
+// serveBodyLimit answers with a 413 and the BodyLimit as JSON
+func serveBodyLimit(w *responseWriter, limit int64) {
+	content, _ := json.Marshal(BodyLimit{Error: BodyLimitError.Error(), Limit: limit})
+	w.Header().Set("Content-Type", mimetypes.ApplicationJson)
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write(content)
+}
+
+// limitedBody fails reads past the limit, if any, and calls read
+// once the body is read, the limit is hit or reading fails. Only the
+// handler reads it, possibly on its own goroutine with a timeout, so
+// exceeded is atomic
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+	over      int32
+	read      func()
+}
+
+func (this *limitedBody) exceeded() bool {
+	return atomic.LoadInt32(&this.over) == 1
+}
+
+// done calls read, once
+func (this *limitedBody) done() {
+	if this.read != nil {
+		this.read()
+		this.read = nil
+	}
+}
+
+func (this *limitedBody) Read(p []byte) (int, error) {
+	if this.exceeded() {
+		return 0, BodyLimitError
+	}
+	if this.limit <= 0 {
+		n, err := this.ReadCloser.Read(p)
+		if err != nil {
+			this.done()
+		}
+		return n, err
+	}
+	// read one byte past the limit, to tell a body of
+	// exactly limit bytes from a larger one
+	if int64(len(p)) > this.remaining+1 {
+		p = p[:this.remaining+1]
+	}
+	n, err := this.ReadCloser.Read(p)
+	if int64(n) <= this.remaining {
+		this.remaining -= int64(n)
+		if err != nil {
+			this.done()
+		}
+		return n, err
+	}
+
+	n = int(this.remaining)
+	this.remaining = 0
+	atomic.StoreInt32(&this.over, 1)
+	this.done()
+	return n, BodyLimitError
+}
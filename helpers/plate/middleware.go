@@ -0,0 +1,104 @@
+package plate
+
+import (
+	"net/http"
+)
+
+// Middleware wraps a handler, running code before and after it,
+// replacing the ResponseWriter or not calling it at all
+type Middleware func(http.Handler) http.Handler
+
+// Adds middleware to every route, outside of the global filters, and
+// to the server's own OPTIONS and 405 responses. The first middleware
+// added is the outermost
+func (this *Server) Use(middleware ...Middleware) {
+	this.middleware = append(this.middleware, middleware...)
+	this.reset()
+}
+
+// Adds middleware to the route, inside of the global filters
+func (this *Route) Use(middleware ...Middleware) *Route {
+	this.middleware = append(this.middleware, middleware...)
+	if this.server != nil {
+		this.server.reset()
+	}
+	return this
+}
+
+// Adds middleware to routes created by the group from now on
+func (this *Group) Use(middleware ...Middleware) {
+	this.middleware = append(this.middleware, middleware...)
+}
+
+// Filter adapts a filter to a Middleware. As with AddFilter, the
+// filter aborts the request by writing to the response, otherwise
+// the next handler is called
+func Filter(filter http.HandlerFunc) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if runFilter(filter, w, r) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// chain builds the handler for the route: the server middleware
+// wrapping the global filters, wrapping the route middleware and
+// finally the route's filters and handler
+func (this *Server) chain(route *Route) http.Handler {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//execute middleware filters for this route, in order
+		// and on this goroutine, aborting like global filters
+		for _, filter := range route.filters {
+			if runFilter(filter, w, r) {
+				return
+			}
+		}
+		if len(route.contenttype) > 0 {
+			w.Header().Set("Content-Type", route.contenttype)
+		}
+
+		//Invoke the request handler
+		route.handler(w, r)
+	})
+
+	for i := len(route.middleware) - 1; i >= 0; i-- {
+		h = route.middleware[i](h)
+	}
+	if !route.unfiltered {
+		h = this.filter(h)
+	}
+	return this.wrap(h)
+}
+
+// wrap wraps h in the server middleware, which also sees the
+// responses the server makes itself, ie to OPTIONS requests
+func (this *Server) wrap(h http.Handler) http.Handler {
+	for i := len(this.middleware) - 1; i >= 0; i-- {
+		h = this.middleware[i](h)
+	}
+	return h
+}
+
+// filter runs the global filters before next, reading Filters on
+// every request so filters appended to it directly still apply
+func (this *Server) filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, filter := range this.Filters {
+			if runFilter(filter, w, r) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runFilter calls filter, reporting whether it wrote to the
+// response and so aborted the request
+func runFilter(filter http.HandlerFunc, w http.ResponseWriter, r *http.Request) bool {
+	fw := &responseWriter{writer: w}
+	filter(fw, r)
+	return fw.started
+}
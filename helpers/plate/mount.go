@@ -0,0 +1,75 @@
+package plate
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Mounts handler under prefix for every method, so another Server,
+// net/http/pprof or any other http.Handler can serve a whole subtree.
+// The prefix is stripped from the request path before handler sees
+// it. Global filters still run, unless NoFilter is called on the
+// returned Route
+func (this *Server) Mount(prefix string, handler http.Handler) *Route {
+	return this.mount("", strings.TrimSuffix(prefix, "/"), handler)
+}
+
+// Mounts handler under the group's prefix joined with prefix
+func (this *Group) Mount(prefix string, handler http.Handler) *Route {
+	route := this.server.mount(this.host, strings.TrimSuffix(this.prefix+prefix, "/"), handler)
+	for _, filter := range this.filters {
+		route.AddFilter(filter)
+	}
+	if len(this.middleware) > 0 {
+		route.Use(this.middleware...)
+	}
+	this.routes = append(this.routes, route)
+	return route
+}
+
+func (this *Server) mount(host, prefix string, handler http.Handler) *Route {
+	//match the prefix itself and everything below it,
+	// but not paths merely sharing it, ie /debugger
+	var tokens []token
+	if len(prefix) > 0 {
+		tokens = append(tokens, token{kind: staticNode, text: prefix})
+	}
+	tokens = append(tokens, token{kind: tailNode, expr: "(/.*)?"})
+
+	route := &Route{}
+	route.server = this
+	route.host = host
+	route.method = ANY
+	route.pattern = prefix + "/*"
+	route.regex = regexp.MustCompile("^" + regexp.QuoteMeta(prefix) + "(/.*)?$")
+	route.tokens = tokens
+	route.handler = stripPrefix(prefix, handler)
+	route.params = []string{""}
+	// the prefix is stripped as is, so it must match exactly
+	route.sensitive = true
+
+	this.Routes = append(this.Routes, route)
+	this.reset()
+
+	return route
+}
+
+// stripPrefix serves the request with prefix removed from its
+// path, leaving at least "/"
+func stripPrefix(prefix string, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		r2.URL = &u
+
+		u.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		if len(u.Path) == 0 {
+			u.Path = "/"
+		}
+		u.RawPath = ""
+
+		handler.ServeHTTP(w, r2)
+	}
+}
@@ -0,0 +1,81 @@
+package plate
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	paramsKey contextKey = iota
+	valuesKey
+	routeKey
+	sessionKey
+	csrfKey
+	requestIDKey
+	principalKey
+)
+
+// withParams returns a copy of the request carrying the route,
+// the values matched by its params and host params, and their
+// converted values. It reports false if a value failed
+// to convert.
+func withParams(r *http.Request, route *Route, values []string, hostParams map[string]string) (*http.Request, bool) {
+	params := make(map[string]string, len(route.params)+len(hostParams))
+	for name, value := range hostParams {
+		params[name] = value
+	}
+	var typed map[string]interface{}
+
+	i := 0
+	for _, tok := range route.tokens {
+		if tok.kind == staticNode {
+			continue
+		}
+		value := values[i]
+		i++
+
+		if tok.conv != nil {
+			v, err := tok.conv.Convert(value)
+			if err != nil {
+				return r, false
+			}
+			if typed == nil {
+				typed = map[string]interface{}{}
+			}
+			typed[tok.text] = v
+		}
+		if len(tok.text) > 0 {
+			params[tok.text] = value
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), routeKey, route)
+	ctx = context.WithValue(ctx, paramsKey, params)
+	if typed != nil {
+		ctx = context.WithValue(ctx, valuesKey, typed)
+	}
+	return r.WithContext(ctx), true
+}
+
+// matched returns the route matched by the request, if any
+func matched(r *http.Request) *Route {
+	route, _ := r.Context().Value(routeKey).(*Route)
+	return route
+}
+
+// Param returns the value matched by the route param name,
+// ie Param(r, "id") for ‘/user/:id’, or Param(r, "tenant") for
+// the host ‘:tenant.example.com’. The leading ":" is optional.
+func Param(r *http.Request, name string) string {
+	return Params(r)[strings.TrimPrefix(name, ":")]
+}
+
+// Params returns every value matched by the route params,
+// keyed by param name.
+func Params(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(paramsKey).(map[string]string)
+	return params
+}
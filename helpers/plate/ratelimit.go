@@ -0,0 +1,190 @@
+package plate
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit configures the rate limiting middleware, see Server.RateLimit.
+// Clients get a bucket of Limit requests, refilled evenly over Window,
+// so bursts of up to Limit requests are allowed.
+type RateLimit struct {
+	Limit  int            // requests allowed per window
+	Window time.Duration  // time for an empty bucket to refill
+	Key    RateLimitKey   // identifies the client, defaults to KeyByIP
+	Store  RateLimitStore // holds the buckets, defaults to a new MemoryStore
+	Name   string         // prefixes keys, to share a Store between limits
+}
+
+// RateLimitKey returns the key a request is counted against
+type RateLimitKey func(r *http.Request) string
+
+// RateLimitStatus is the state of a client's bucket after a request
+type RateLimitStatus struct {
+	Allowed   bool          // the request may proceed
+	Remaining int           // requests left in the bucket
+	Reset     time.Duration // until the bucket is full again
+	Retry     time.Duration // until the next request is allowed, if not Allowed
+}
+
+// RateLimitStore keeps the buckets, ie in memory or in a backend
+// shared between servers. Take removes a request from key's bucket
+type RateLimitStore interface {
+	Take(key string, limit int, window time.Duration) (RateLimitStatus, error)
+}
+
+// RateLimit returns middleware answering clients that exceed the limit
+// with a 429 and a Retry-After header. Every response carries the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// Rejections are counted by the StatusService. ie:
+//
+//	server.Post("/login", controllers.Login).Use(server.RateLimit(plate.RateLimit{
+//		Limit:  5,
+//		Window: time.Minute,
+//	}))
+//
+// If the Store fails the request is let through and the error logged.
+// It panics unless Limit and Window are positive.
+func (this *Server) RateLimit(limit RateLimit) Middleware {
+	if limit.Limit <= 0 || limit.Window <= 0 {
+		panic(fmt.Errorf("RateLimit %s: Limit and Window must be positive, got %d per %s",
+			limit.Name, limit.Limit, limit.Window))
+	}
+	if limit.Key == nil {
+		limit.Key = KeyByIP
+	}
+	if limit.Store == nil {
+		limit.Store = NewMemoryStore()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status, err := limit.Store.Take(limit.Name+limit.Key(r), limit.Limit, limit.Window)
+			if err != nil {
+				this.Logger.Printf("rate limit: %s", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(status.Remaining))
+			w.Header().Set("RateLimit-Reset", seconds(status.Reset))
+			if !status.Allowed {
+				this.StatusService.Reject()
+				w.Header().Set("Retry-After", seconds(status.Retry))
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// seconds formats d as whole seconds, rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// KeyByIP counts requests against the client's IP address
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader counts requests against the value of a header, ie
+// an API key, falling back to the client's IP address without one
+func KeyByHeader(name string) RateLimitKey {
+	return func(r *http.Request) string {
+		if value := r.Header.Get(name); len(value) > 0 {
+			return name + ":" + value
+		}
+		return KeyByIP(r)
+	}
+}
+
+// KeyBySession counts requests against the session, giving it a random
+// id if it has none, falling back to the client's IP address when the
+// request has no session
+func KeyBySession(sessions *RequestSessions) RateLimitKey {
+	return func(r *http.Request) string {
+		session := sessions.Get(r)
+		if session == nil {
+			return KeyByIP(r)
+		}
+		id, _ := session[sessionIdKey].(string)
+		if len(id) == 0 {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				return KeyByIP(r)
+			}
+			id = base64.RawURLEncoding.EncodeToString(b)
+			session[sessionIdKey] = id
+		}
+		return "session:" + id
+	}
+}
+
+const sessionIdKey = "_id"
+
+// MemoryStore is a RateLimitStore for a single server
+type MemoryStore struct {
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, swept: time.Now()}
+}
+
+func (this *MemoryStore) Take(key string, limit int, window time.Duration) (RateLimitStatus, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	now := time.Now()
+	rate := float64(limit) / float64(window) // tokens per nanosecond
+
+	// forget buckets that have refilled, now and again
+	if now.Sub(this.swept) > window {
+		for k, b := range this.buckets {
+			if now.Sub(b.updated) > window {
+				delete(this.buckets, k)
+			}
+		}
+		this.swept = now
+	}
+
+	b, ok := this.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit), updated: now}
+		this.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit), b.tokens+float64(now.Sub(b.updated))*rate)
+	b.updated = now
+
+	status := RateLimitStatus{}
+	if b.tokens >= 1 {
+		b.tokens--
+		status.Allowed = true
+	} else {
+		status.Retry = time.Duration((1 - b.tokens) / rate)
+	}
+	status.Remaining = int(b.tokens)
+	status.Reset = time.Duration((float64(limit) - b.tokens) / rate)
+	return status, nil
+}
@@ -0,0 +1,95 @@
+package plate
+
+import (
+	"../mimetypes"
+	"bytes"
+	"html/template"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	// error page rendered when a handler panics, see ServerConfig.ErrorTemplate
+	ErrorTemplate = "templates/500.html"
+)
+
+// PanicReporter is told about every panic recovered while serving
+// a request, ie to forward it to an error tracking service
+type PanicReporter interface {
+	ReportPanic(r *http.Request, err interface{}, stack []byte)
+}
+
+// PanicReporterFunc adapts a func to a PanicReporter
+type PanicReporterFunc func(r *http.Request, err interface{}, stack []byte)
+
+func (f PanicReporterFunc) ReportPanic(r *http.Request, err interface{}, stack []byte) {
+	f(r, err, stack)
+}
+
+// recovering reports whether panics in handlers should be recovered
+func (this *Server) recovering() bool {
+	return this.Config != nil && this.Config.RecoverPanic
+}
+
+// panicked handles a panic raised while serving r: it logs the stack
+// trace, tells the PanicReporter and answers with a 500 when nothing
+// has been written yet
+func (this *Server) panicked(w *responseWriter, r *http.Request, err interface{}, start time.Time) {
+	stack := debug.Stack()
+	if p, ok := err.(*handlerPanic); ok {
+		err, stack = p.value, p.stack
+	}
+	this.reportPanic(r, err, stack)
+
+	if !w.started {
+		// the handler may have set headers for the response
+		// it was going to write, ie Content-Encoding by gzip
+		w.Header().Del("Content-Encoding")
+		this.ServeError(w, http.StatusInternalServerError)
+	}
+
+	dur := time.Since(start)
+	this.StatusService.Update(w.status, &dur)
+}
+
+// reportPanic logs the panic and tells the PanicReporter
+func (this *Server) reportPanic(r *http.Request, err interface{}, stack []byte) {
+	this.Logger.Printf("panic serving %s %s: %v (request %s)\n%s", r.Method, r.URL.Path, err, RequestID(r), stack)
+
+	if this.PanicReporter != nil {
+		this.PanicReporter.ReportPanic(r, err, stack)
+	}
+}
+
+// ServeError replies with status, rendered through the error template
+// of the server config. The template is given the Status and its
+// StatusText; plain text is sent if it can't be rendered
+func (this *Server) ServeError(w http.ResponseWriter, status int) {
+	file := ErrorTemplate
+	if this.Config != nil && len(this.Config.ErrorTemplate) > 0 {
+		file = this.Config.ErrorTemplate
+	}
+
+	bag := map[string]interface{}{
+		"Status":     status,
+		"StatusText": http.StatusText(status),
+	}
+
+	// render into a buffer first, so a failing template
+	// doesn't leave a half written page behind
+	var buf bytes.Buffer
+	tmpl, err := template.ParseFiles(file)
+	if err == nil {
+		err = tmpl.Execute(&buf, bag)
+	}
+	if err != nil {
+		this.Logger.Println(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimetypes.TextHtml)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
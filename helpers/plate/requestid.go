@@ -0,0 +1,55 @@
+package plate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	// header carrying the id of a request, accepted from
+	// clients and proxies and sent back with the response
+	RequestIDHeader = "X-Request-ID"
+)
+
+// RequestID returns the id of the request, as logged in the
+// access log, or "" if it wasn't served by a Server
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// withRequestID returns a copy of the request carrying its id. A
+// request already tagged, ie by a server this one is mounted on,
+// keeps its id. Otherwise it is taken from the RequestIDHeader
+// when it is sensible, and generated if not
+func withRequestID(r *http.Request) (*http.Request, string) {
+	if id := RequestID(r); len(id) > 0 {
+		return r, id
+	}
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey, id)), id
+}
+
+// validRequestID accepts ids that are safe to log and echo back
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' || id[i] == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
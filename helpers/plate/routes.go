@@ -3,22 +3,37 @@ package plate
 import (
 	"net/http"
 	"regexp"
-	"strings"
+	"time"
 )
 
 type Route struct {
+	server      *Server
+	name        string
+	host        string
 	method      string
+	pattern     string
 	regex       *regexp.Regexp
-	params      map[int]string
+	tokens      []token
+	params      []string
 	handler     http.HandlerFunc
 	sensitive   bool
 	filters     []http.HandlerFunc
+	middleware  []Middleware
+	after       []AfterFilterFunc
+	hooks       []ResponseHook
 	contenttype string
 	unfiltered  bool // this will ignore all global filters on this route
+	nocsrf      bool // skipped by the CSRF middleware
+	maxBody     int64
+	timeout     time.Duration
 }
 
+// Makes the route match the request path case sensitively
 func (this *Route) Sensitive() *Route {
 	this.sensitive = true
+	if this.server != nil {
+		this.server.reset()
+	}
 	return this
 }
 
@@ -30,21 +45,22 @@ func (this *Route) ContentType(contenttype string) *Route {
 
 func (this *Route) NoFilter() *Route {
 	this.unfiltered = true
+	if this.server != nil {
+		this.server.reset()
+	}
 	return this
 }
 
-// Add middleware filter to specific route
+// Add middleware filter to specific route. Route filters run in the
+// order added, after the global filters, and like them abort the
+// request by writing to the response
 func (this *Route) AddFilter(filter http.HandlerFunc) {
 	this.filters = append(this.filters, filter)
 }
 
 func (this *Route) FilterParam(param string, filter http.HandlerFunc) {
-	if !strings.HasPrefix(param, ":") {
-		param = ":" + param
-	}
-
 	this.AddFilter(func(w http.ResponseWriter, r *http.Request) {
-		p := r.URL.Query().Get(param)
+		p := Param(r, param)
 		if len(p) > 0 {
 			filter(w, r)
 		}
@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"html/template"
 	"io"
-	"io/ioutil"
 	"log"
 	"mime"
 	"net/http"
@@ -33,9 +32,10 @@ const (
 	POST    = "POST"
 	PUT     = "PUT"
 	TRACE   = "TRACE"
+	ANY     = "*" // matches every method, see Mount
 
 	// log format, modeled after http://wiki.nginx.org/HttpLogModule
-	LOG = `%s - - [%s] "%s %s %s" %d %d "%s" "%s"`
+	LOG = `%s - - [%s] "%s %s %s" %d %d "%s" "%s" %s`
 
 	blockSize = 16 // we want 16 byte blocks, for AES-128
 )
@@ -72,6 +72,25 @@ type Server struct {
 	Config         *ServerConfig
 	Filters        []http.HandlerFunc
 	StatusService  *StatusService
+	PanicReporter  PanicReporter // told about recovered panics, see ServerConfig.RecoverPanic
+	QueryParams    bool          // also add route params to the query string, as ":name"
+	Validating     bool          // record registration errors for Validate instead of panicking
+	SlashPolicy    SlashPolicy
+	Authorizer     Authorizer    // decides Require, defaults to RoleAuthorizer
+	LoginRoute     string        // name of the route browsers denied by Require are redirected to
+	MaxBody        int64         // limit of request bodies in bytes, 0 for none, see Route.MaxBody
+	BodyTimeout    time.Duration // time allowed to read a request body, 0 for no limit
+	Timeout        time.Duration // time allowed to handle a request, 0 for no limit, see Route.Timeout
+	TimeoutBody    string        // sent with the 503 when a handler times out
+	router         *router
+	names          map[string]*Route
+	converters     map[string]*Converter
+	errors         []error
+	middleware     []Middleware
+	after          []AfterFilterFunc
+	hooks          []ResponseHook
+	denyHooks      []DenyHook
+	lock           sync.RWMutex
 }
 
 //responseWriter is a wrapper for the http.ResponseWriter
@@ -81,8 +100,11 @@ type Server struct {
 type responseWriter struct {
 	writer  http.ResponseWriter // Writer
 	started bool
+	discard bool // drop the body, ie for HEAD requests
 	size    int
 	status  int
+	header  func(int)    // called once, before the header is written
+	body    *limitedBody // once read past its limit, writes are dropped
 }
 
 type gzipResponseWriter struct {
@@ -102,51 +124,68 @@ func NewServer(session_key ...string) *Server {
 	}
 	server.SetLogger(server.Logger)
 	if len(session_key) != 0 && len(session_key[0]) != 0 {
-		server.NewSessionHandler(session_key[0], nil)
+		server.SessionHandler = server.NewSessionHandler(session_key[0], nil)
 	}
 
 	server.StatusService = NewStatusService()
 	return server
 }
 
-// Adds a new Route to the Handler
+// Adds a new Route to the Handler. An invalid pattern panics, unless
+// the server is Validating, in which case the error is recorded for
+// Validate and a Route that is never matched is returned
 func (this *Server) AddRoute(method string, pattern string, handler http.HandlerFunc) *Route {
-	//split the url into sections
-	parts := strings.Split(pattern, "/")
-
-	//find params that start with ":"
-	//replace with regular expressions
-	j := 0
-	params := make(map[int]string)
-	for i, part := range parts {
-		if strings.HasPrefix(part, ":") {
-			expr := "([^/]+)"
-			//a user may choose to override the defult expression
-			// similar to expressjs: ‘/user/:id([0-9]+)’
-			if index := strings.Index(part, "("); index != -1 {
-				expr = part[index:]
-				part = part[:index]
-			}
-			params[j] = part
-			parts[i] = expr
-			j++
+	return this.addRoute("", method, pattern, handler)
+}
+
+func (this *Server) addRoute(host, method, pattern string, handler http.HandlerFunc) *Route {
+	route, err := this.tryAddRoute(host, method, pattern, handler)
+	if err != nil {
+		if !this.Validating {
+			panic(err)
 		}
+		this.errors = append(this.errors, err)
+		return &Route{method: method, pattern: pattern}
 	}
+	return route
+}
+
+// Adds a new Route to the Handler, returning an error if
+// the pattern is invalid
+func (this *Server) TryAddRoute(method string, pattern string, handler http.HandlerFunc) (*Route, error) {
+	return this.tryAddRoute("", method, pattern, handler)
+}
 
-	//recreate the url pattern, with parameters replaced
-	//by regular expressions. then compile the regex
-	pattern = strings.Join(parts, "/")
-	regex, regexErr := regexp.Compile(pattern)
-	if regexErr != nil {
-		//TODO add error handling here to avoid panic
-		panic(regexErr)
-		return nil
+func (this *Server) tryAddRoute(host, method, pattern string, handler http.HandlerFunc) (*Route, error) {
+	//split the url into static text and params, and build
+	// the equivalent regular expression. parameters may
+	// override the default expression, ie ‘/user/:id([0-9]+)’
+	// or name a converter, ie ‘/user/:id<int>’
+	tokens, params, expr, err := parsePattern(pattern, this.converter)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", method, pattern, err)
+	}
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", method, pattern, err)
+	}
+	for _, tok := range tokens {
+		if tok.expr == "" {
+			continue
+		}
+		if _, err = regexp.Compile(tok.expr); err != nil {
+			return nil, fmt.Errorf("%s %s: %v", method, pattern, err)
+		}
 	}
 
 	//now create the Route
 	route := &Route{}
+	route.server = this
+	route.host = host
 	route.method = method
+	route.pattern = pattern
 	route.regex = regex
+	route.tokens = tokens
 	route.handler = makeGzipHandler(handler)
 	route.params = params
 	route.sensitive = false
@@ -154,8 +193,46 @@ func (this *Server) AddRoute(method string, pattern string, handler http.Handler
 
 	//and finally append to the list of Routes
 	this.Routes = append(this.Routes, route)
+	this.reset()
 
-	return route
+	return route, nil
+}
+
+// reset discards the prefix tree so it is rebuilt from
+// Routes on the next request
+func (this *Server) reset() {
+	this.lock.Lock()
+	this.router = nil
+	this.lock.Unlock()
+}
+
+// routes returns the prefix tree, building it first if
+// the routes have changed
+func (this *Server) routes() *router {
+	this.lock.RLock()
+	rt := this.router
+	this.lock.RUnlock()
+
+	if rt == nil {
+		this.lock.Lock()
+		if this.router == nil {
+			this.router = newRouter(this.Routes)
+			this.router.chains = make(map[*Route]http.Handler, len(this.Routes))
+			for _, route := range this.Routes {
+				this.router.chains[route] = this.chain(route)
+			}
+		}
+		rt = this.router
+		this.lock.Unlock()
+	}
+
+	return rt
+}
+
+// match finds the Route for the request method and path
+func (this *Server) match(method, path string) (*Route, []string) {
+	route, values, _ := this.routes().match(method, "", path)
+	return route, values
 }
 
 // Adds a new Route for GET requests
@@ -187,10 +264,12 @@ func (this *Server) Post(pattern string, handler http.HandlerFunc) *Route {
 // Adds a new Route for Static http requests. Serves
 // static files from the specified directory
 func (this *Server) Static(pattern string, dir string) *Route {
-	//append a regex to the param to match everything
+	//append a catch-all param to match everything
 	// that comes after the prefix
-	pattern = pattern + "(.+)"
-	return this.AddRoute(GET, pattern, func(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(pattern, "/") {
+		pattern += "/"
+	}
+	return this.AddRoute(GET, pattern+"*filepath", func(w http.ResponseWriter, r *http.Request) {
 		path := filepath.Clean(r.URL.Path)
 		path = filepath.Join(dir, path)
 		ext := filepath.Ext(path)
@@ -207,12 +286,8 @@ func (this *Server) AddFilter(filter http.HandlerFunc) {
 
 // FIlterParam adds the middleware filter if the REST URL parameter exists.
 func (this *Server) FilterParam(param string, filter http.HandlerFunc) {
-	if !strings.HasPrefix(param, ":") {
-		param = ":" + param
-	}
-
 	this.AddFilter(func(w http.ResponseWriter, r *http.Request) {
-		p := r.URL.Query().Get(param)
+		p := Param(r, param)
 		if len(p) > 0 {
 			filter(w, r)
 		}
@@ -224,82 +299,77 @@ func (this *Server) FilterParam(param string, filter http.HandlerFunc) {
 func (this *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 	start_time := time.Now()
-	requestPath := r.URL.Path
+
+	//tag the request with an id, for correlating log lines
+	r, id := withRequestID(r)
+	rw.Header().Set(RequestIDHeader, id)
 
 	//wrap the response writer, in our custom interface
 	w := &responseWriter{writer: rw}
-
-	//find a matching Route
-	for _, route := range this.Routes {
-
-		//if the methods don't match, skip this handler
-		//i.e if request.Method is 'PUT' Route.Method must be 'PUT'
-		if r.Method != route.method {
-			continue
-		}
-
-		// check if route is case sensitive or not
-		if route.sensitive == false {
-			str := route.regex.String()
-			reg, err := regexp.Compile(strings.ToLower(str))
-
-			if err == nil {
-				route.regex = reg
-				requestPath = strings.ToLower(requestPath)
+	w.header = func(status int) { this.respond(w, r, nil, status) }
+
+	//after filters run last, even if the handler panics
+	var route *Route
+	completed := false
+	defer func() { this.afterFilter(w, r, route, start_time, completed) }()
+	if this.recovering() {
+		defer func() {
+			if err := recover(); err != nil {
+				this.panicked(w, r, err, start_time)
 			}
-		}
-
-		//check if Route pattern matches url
-		if !route.regex.MatchString(requestPath) {
-			continue
-		}
+		}()
+	}
 
-		//get submatches (params)
-		matches := route.regex.FindStringSubmatch(requestPath)
+	//find a matching Route, HEAD requests fall back to
+	// the GET route and have their body discarded
+	rt := this.routes()
+	route, values, hostParams, path := this.find(rt, r.Method, r.Host, r.URL.Path)
+	if route == nil && r.Method == HEAD {
+		route, values, hostParams, path = this.find(rt, GET, r.Host, r.URL.Path)
+		w.discard = true
+	}
 
-		//double check that the Route matches the URL pattern.
-		if len(matches[0]) != len(requestPath) {
-			continue
+	if route == nil {
+		//the path may still match routes for other methods
+		if allow := rt.allowed(r.Host, r.URL.Path); len(allow) > 0 {
+			this.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Allow", strings.Join(allow, ", "))
+				if r.Method == OPTIONS {
+					w.WriteHeader(http.StatusOK)
+				} else {
+					http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+				}
+			})).ServeHTTP(w, r)
 		}
-
-		if len(route.params) > 0 {
+	} else if path != r.URL.Path && this.SlashPolicy != SlashMatch {
+		//only the trailing slash differs from the route
+		this.redirectSlash(w, r, path)
+	} else if req, ok := withParams(r, route, values, hostParams); ok {
+		//values failing their param's converter fall
+		// through to not found
+		r = req
+
+		if len(route.params) > 0 && this.QueryParams {
 			//add url parameters to the query param map
-			values := r.URL.Query()
-			for i, match := range matches[1:] {
-				values.Add(route.params[i], match)
+			params := r.URL.Query()
+			for i, name := range route.params {
+				if len(name) > 0 {
+					params.Add(":"+name, values[i])
+				}
 			}
 
 			//reassemble query params and add to RawQuery
-			r.URL.RawQuery = url.Values(values).Encode() + "&" + r.URL.RawQuery
-			//r.URL.RawQuery = url.Values(values).Encode()
+			r.URL.RawQuery = url.Values(params).Encode() + "&" + r.URL.RawQuery
 		}
 
-		if !route.unfiltered {
-			// execute global middleware filters
-			for _, filter := range this.Filters {
-				//go func() {
-				filter(w, r)
-				//}()
-				if w.started {
-					return
-				}
-			}
-		}
+		w.header = func(status int) { this.respond(w, r, route, status) }
 
-		//execute middleware filters for this route
-		for _, filter := range route.filters {
-			go func() {
-				filter(w, r)
-			}()
-			if w.started {
-				return
-			}
+		//run the middleware chain, ending with the handler,
+		// unless the body is already known to be too large
+		if this.limitBody(w, r, route, rw) {
+			this.serveRoute(rt.chains[route], w, r, route)
+			this.overLimit(w)
 		}
-		w.Header().Set("Content-Type", route.contenttype)
-
-		//Invoke the request handler
-		route.handler(w, r)
-		break
 	}
 
 	//if no matches to url, throw a not found exception
@@ -315,8 +385,9 @@ func (this *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	if this.Logging {
 		this.Logger.Printf(LOG, r.RemoteAddr, time.Now().String(), r.Method,
 			r.URL.Path, r.Proto, w.status, w.size,
-			r.Referer(), r.UserAgent())
+			r.Referer(), r.UserAgent(), RequestID(r))
 	}
+	completed = true
 }
 
 func (w gzipResponseWriter) Write(b []byte) (int, error) {
@@ -331,8 +402,10 @@ func makeGzipHandler(fn http.HandlerFunc) http.HandlerFunc {
 		}
 		w.Header().Set("Content-Encoding", "gzip")
 		gz := gzip.NewWriter(w)
-		defer gz.Close()
 		fn(gzipResponseWriter{Writer: gz, ResponseWriter: w}, r)
+		// not deferred, so a panicking handler leaves the response
+		// unwritten for the 500, rather than an empty gzip stream
+		gz.Close()
 	}
 }
 
@@ -347,14 +420,29 @@ func (this *responseWriter) Header() http.Header {
 // Write writes the data to the connection as part of an HTTP reply,
 // and sets `started` to true
 func (this *responseWriter) Write(p []byte) (int, error) {
+	if this.body != nil && this.body.exceeded() {
+		return len(p), nil
+	}
+	if this.status == 0 {
+		this.WriteHeader(http.StatusOK)
+	}
 	this.size += len(p)
 	this.started = true
+	if this.discard {
+		return len(p), nil
+	}
 	return this.writer.Write(p)
 }
 
 // WriteHeader sends an HTTP response header with status code,
-// and sets `started` to true
+// and sets `started` to true. Only the first call has any effect
 func (this *responseWriter) WriteHeader(code int) {
+	if this.status != 0 || (this.body != nil && this.body.exceeded()) {
+		return
+	}
+	if this.header != nil {
+		this.header(code)
+	}
 	this.status = code
 	this.started = true
 	this.writer.WriteHeader(code)
@@ -381,12 +469,8 @@ func ServeJson(w http.ResponseWriter, v interface{}) {
 // Request object and stores the result in the value
 // pointed to by v.
 func ReadJson(r *http.Request, v interface{}) error {
-	body, err := ioutil.ReadAll(r.Body)
-	r.Body.Close()
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(body, v)
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(v)
 }
 
 // ServeXml replies to the request with an XML
@@ -405,12 +489,8 @@ func ServeXml(w http.ResponseWriter, v interface{}) {
 // Request object and stores the result in the value
 // pointed to by v.
 func ReadXml(r *http.Request, v interface{}) error {
-	body, err := ioutil.ReadAll(r.Body)
-	r.Body.Close()
-	if err != nil {
-		return err
-	}
-	return xml.Unmarshal(body, v)
+	defer r.Body.Close()
+	return xml.NewDecoder(r.Body).Decode(v)
 }
 
 // ServeFormatted replies to the request with
@@ -449,11 +529,12 @@ func SetLogger(logger *log.Logger) {
    -------------------------------- */
 
 type ServerConfig struct {
-	StaticDir    string
-	Addr         string
-	Port         int
-	CookieSecret string
-	RecoverPanic bool
+	StaticDir     string
+	Addr          string
+	Port          int
+	CookieSecret  string
+	RecoverPanic  bool   // answer panicking handlers with a 500 rather than dropping the connection
+	ErrorTemplate string // page rendered for the 500, defaults to ErrorTemplate
 }
 
 func Serve404(w http.ResponseWriter, error string) {
@@ -475,6 +556,7 @@ type StatusService struct {
 	Pid               int
 	ResponseCounts    map[string]int
 	TotalResponseTime time.Time
+	RateLimited       int // requests rejected by rate limits
 }
 
 func NewStatusService() *StatusService {
@@ -493,6 +575,13 @@ func (self *StatusService) Update(status_code int, response_time *time.Duration)
 	self.Lock.Unlock()
 }
 
+// Reject counts a request rejected by a rate limit
+func (self *StatusService) Reject() {
+	self.Lock.Lock()
+	self.RateLimited++
+	self.Lock.Unlock()
+}
+
 type Status struct {
 	Pid                    int
 	UpTime                 string
@@ -505,6 +594,7 @@ type Status struct {
 	TotalResponseTimeSec   float64
 	AverageResponseTime    string
 	AverageResponseTimeSec float64
+	RateLimitedCount       int
 }
 
 func (self *StatusService) GetStatus(w http.ResponseWriter, r *http.Request) {
@@ -538,6 +628,7 @@ func (self *StatusService) GetStatus(w http.ResponseWriter, r *http.Request) {
 		TotalResponseTimeSec:   TotalResponseTime.Seconds(),
 		AverageResponseTime:    average_response_time.String(),
 		AverageResponseTimeSec: average_response_time.Seconds(),
+		RateLimitedCount:       self.RateLimited,
 	}
 
 	jsonBytes, err := json.Marshal(st)
@@ -0,0 +1,1516 @@
+package plate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer() *Server {
+	server := NewServer()
+	server.Logging = false
+	return server
+}
+
+func serve(server *Server, method, path string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	return w
+}
+
+// writes the route name and the values of the given route params
+func echo(name string, params ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := name
+		for _, p := range params {
+			out += " " + Param(r, p)
+		}
+		w.Write([]byte(out))
+	}
+}
+
+func TestRouteMatching(t *testing.T) {
+	server := newTestServer()
+	server.Get("/", echo("index"))
+	server.Get("/user/:id", echo("user.show", "id"))
+	server.Get("/user/new", echo("user.new"))
+	server.Get("/user/:id/edit", echo("user.edit", "id"))
+	server.Get("/post/:id([0-9]+)", echo("post.id", "id"))
+	server.Get("/post/:slug", echo("post.slug", "slug"))
+	server.Get("/files/:file(.+)", echo("files", "file"))
+	server.Get("/About", echo("about.exact")).Sensitive()
+	server.Get("/contact", echo("contact"))
+	server.Post("/user/:id", echo("user.update", "id"))
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{GET, "/", 200, "index"},
+		{GET, "/user/42", 200, "user.show 42"},
+		{GET, "/user/new", 200, "user.new"},
+		{GET, "/user/newer", 200, "user.show newer"},
+		{GET, "/user/42/edit", 200, "user.edit 42"},
+		{GET, "/user/", 404, ""},
+		{GET, "/post/12", 200, "post.id 12"},
+		{GET, "/post/hello-world", 200, "post.slug hello-world"},
+		{GET, "/files/css/style.css", 200, "files css/style.css"},
+		{GET, "/About", 200, "about.exact"},
+		{GET, "/about", 404, ""},
+		{GET, "/CONTACT", 200, "contact"},
+		{GET, "/User/AbC", 200, "user.show AbC"},
+		{POST, "/user/42", 200, "user.update 42"},
+		{GET, "/missing", 404, ""},
+	}
+
+	for _, test := range tests {
+		w := serve(server, test.method, test.path)
+		if w.Code != test.code {
+			t.Errorf("%s %s: code %d expected, got: %d", test.method, test.path, test.code, w.Code)
+			continue
+		}
+		if test.code == 200 && w.Body.String() != test.body {
+			t.Errorf("%s %s: body '%s' expected, got: '%s'", test.method, test.path, test.body, w.Body.String())
+		}
+	}
+}
+
+func TestRoutesAddedAfterServing(t *testing.T) {
+	server := newTestServer()
+	server.Get("/a", echo("a"))
+	if w := serve(server, GET, "/b"); w.Code != 404 {
+		t.Fatalf("code 404 expected, got: %d", w.Code)
+	}
+
+	server.Get("/b", echo("b"))
+	if w := serve(server, GET, "/b"); w.Body.String() != "b" {
+		t.Fatalf("body 'b' expected, got: '%s'", w.Body.String())
+	}
+}
+
+/* Benchmarks
+   ------------------------------- */
+
+// linearRoute reproduces the original matching strategy, one
+// regular expression per route tested in registration order.
+type linearRoute struct {
+	method string
+	regex  *regexp.Regexp
+}
+
+func linearMatch(routes []linearRoute, method, path string) *linearRoute {
+	for i := range routes {
+		route := &routes[i]
+		if method != route.method {
+			continue
+		}
+		if !route.regex.MatchString(path) {
+			continue
+		}
+		matches := route.regex.FindStringSubmatch(path)
+		if len(matches[0]) != len(path) {
+			continue
+		}
+		return route
+	}
+	return nil
+}
+
+func benchmarkPatterns(n int) []string {
+	var patterns []string
+	for i := 0; i < n/4; i++ {
+		patterns = append(patterns,
+			fmt.Sprintf("/api/v1/resource%d", i),
+			fmt.Sprintf("/api/v1/resource%d/:id([0-9]+)", i),
+			fmt.Sprintf("/api/v1/resource%d/:id/children", i),
+			fmt.Sprintf("/api/v1/resource%d/:id/children/:child", i),
+		)
+	}
+	return patterns
+}
+
+func benchmarkPaths(n int) []string {
+	return []string{
+		"/api/v1/resource0",
+		fmt.Sprintf("/api/v1/resource%d/42", n/8),
+		fmt.Sprintf("/api/v1/resource%d/42/children/7", n/4-1),
+		"/api/v1/missing",
+	}
+}
+
+func benchmarkTree(b *testing.B, n int) {
+	server := newTestServer()
+	for _, pattern := range benchmarkPatterns(n) {
+		server.Get(pattern, echo("bench"))
+	}
+	paths := benchmarkPaths(n)
+	server.match(GET, "/")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range paths {
+			server.match(GET, path)
+		}
+	}
+}
+
+func benchmarkLinear(b *testing.B, n int) {
+	var routes []linearRoute
+	for _, pattern := range benchmarkPatterns(n) {
+		parts := strings.Split(pattern, "/")
+		for i, part := range parts {
+			if strings.HasPrefix(part, ":") {
+				expr := "([^/]+)"
+				if index := strings.Index(part, "("); index != -1 {
+					expr = part[index:]
+				}
+				parts[i] = expr
+			}
+		}
+		regex := regexp.MustCompile(strings.Join(parts, "/"))
+		routes = append(routes, linearRoute{method: GET, regex: regex})
+	}
+	paths := benchmarkPaths(n)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range paths {
+			linearMatch(routes, GET, path)
+		}
+	}
+}
+
+func BenchmarkTree10(b *testing.B)     { benchmarkTree(b, 10) }
+func BenchmarkTree100(b *testing.B)    { benchmarkTree(b, 100) }
+func BenchmarkTree1000(b *testing.B)   { benchmarkTree(b, 1000) }
+func BenchmarkLinear10(b *testing.B)   { benchmarkLinear(b, 10) }
+func BenchmarkLinear100(b *testing.B)  { benchmarkLinear(b, 100) }
+func BenchmarkLinear1000(b *testing.B) { benchmarkLinear(b, 1000) }
+
+func TestReverseURL(t *testing.T) {
+	server := newTestServer()
+	server.Get("/user/:id([0-9]+)", echo("user.show", "id")).Name("user.show")
+	server.Get("/user/:id/posts/:slug", echo("user.post", "id", "slug")).Name("user.post")
+	server.Get("/files/:file(.+)", echo("files", "file")).Name("files")
+
+	tests := []struct {
+		name     string
+		params   []interface{}
+		expected string
+		fails    bool
+	}{
+		{"user.show", []interface{}{"id", 42}, "/user/42", false},
+		{"user.show", []interface{}{":id", "7"}, "/user/7", false},
+		{"user.show", []interface{}{"id", "abc"}, "", true},
+		{"user.show", nil, "", true},
+		{"user.post", []interface{}{"id", 1, "slug", "hello world"}, "/user/1/posts/hello%20world", false},
+		{"user.post", []interface{}{"id", 1, "slug", "a/b"}, "", true},
+		{"files", []interface{}{"file", "css/style.css"}, "/files/css/style.css", false},
+		{"missing", nil, "", true},
+	}
+
+	for _, test := range tests {
+		u, err := server.URL(test.name, test.params...)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s %v: error expected, got: %s", test.name, test.params, u)
+			}
+			continue
+		}
+		if err != nil || u != test.expected {
+			t.Errorf("%s %v: '%s' expected, got: '%s' (%v)", test.name, test.params, test.expected, u, err)
+		}
+	}
+
+	other := newTestServer()
+	other.Get("/other", echo("other")).Name("other.only")
+	if u, err := server.URL("other.only"); err == nil {
+		t.Errorf("names of other servers expected not to resolve, got: '%s'", u)
+	}
+}
+
+func TestURLTemplateFunc(t *testing.T) {
+	server := newTestServer()
+	server.Get("/user/:id", echo("user.show", "id")).Name("user.show")
+
+	w := httptest.NewRecorder()
+	tmpl, _ := server.Template(w)
+	tmpl.HtmlTemplate = template.Must(template.New("link").Funcs(tmpl.FuncMap).Parse(`<a href="{{url "user.show" "id" .}}">`))
+	if err := tmpl.HtmlTemplate.Execute(w, 42); err != nil {
+		t.Fatal(err)
+	}
+	if body := w.Body.String(); body != `<a href="/user/42">` {
+		t.Errorf("Body '<a href=\"/user/42\">' expected, got: '%s'", body)
+	}
+
+	// NewTemplate links through the server that matched the request
+	server.Get("/profile", func(w http.ResponseWriter, r *http.Request) {
+		tmpl := NewTemplate(w, r)
+		tmpl.HtmlTemplate = template.Must(template.New("link").Funcs(tmpl.FuncMap).Parse(`<a href="{{url "user.show" "id" 7}}">`))
+		if err := tmpl.HtmlTemplate.Execute(w, tmpl.Bag); err != nil {
+			t.Error(err)
+		}
+	})
+	if body := serve(server, GET, "/profile").Body.String(); body != `<a href="/user/7">` {
+		t.Errorf("Body '<a href=\"/user/7\">' expected, got: '%s'", body)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	server := newTestServer()
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+
+	api := server.Group("/api/v1/", noop)
+	api.Get("/", echo("api.index"))
+	api.Get("/users/:id", echo("api.user", "id"))
+	admin := api.Group("/admin", noop)
+	route := admin.Get("/Stats", echo("admin.stats")).Sensitive().NoFilter()
+
+	if len(route.filters) != 2 {
+		t.Errorf("2 route filters expected, got: %d", len(route.filters))
+	}
+	if !route.sensitive || !route.unfiltered {
+		t.Errorf("route modifiers not applied to group route")
+	}
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/api/v1", 200, "api.index"},
+		{"/api/v1/users/3", 200, "api.user 3"},
+		{"/api/v1/admin/Stats", 200, "admin.stats"},
+		{"/api/v1/admin/stats", 404, ""},
+		{"/users/3", 404, ""},
+	}
+	for _, test := range tests {
+		w := serve(server, GET, test.path)
+		if w.Code != test.code || (test.code == 200 && w.Body.String() != test.body) {
+			t.Errorf("%s: %d '%s' expected, got: %d '%s'", test.path, test.code, test.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	server := newTestServer()
+	server.Get("/user/:id", echo("user.show", "id"))
+	server.Put("/user/:id", echo("user.update", "id"))
+	server.Get("/ping", echo("ping"))
+	server.AddRoute(HEAD, "/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Head", "explicit")
+	})
+
+	w := serve(server, POST, "/user/1")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Code 405 expected, got: %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS, PUT" {
+		t.Errorf("Allow 'GET, HEAD, OPTIONS, PUT' expected, got: '%s'", allow)
+	}
+
+	w = serve(server, OPTIONS, "/user/1")
+	if w.Code != http.StatusOK || w.Header().Get("Allow") != "GET, HEAD, OPTIONS, PUT" {
+		t.Errorf("OPTIONS: 200 with Allow expected, got: %d '%s'", w.Code, w.Header().Get("Allow"))
+	}
+
+	w = serve(server, HEAD, "/user/1")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("HEAD: 200 without body expected, got: %d '%s'", w.Code, w.Body.String())
+	}
+
+	w = serve(server, HEAD, "/ping")
+	if w.Header().Get("X-Head") != "explicit" {
+		t.Errorf("HEAD: explicit route expected to handle request")
+	}
+
+	w = serve(server, DELETE, "/missing")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Code 404 expected, got: %d", w.Code)
+	}
+}
+
+func TestParamsInContext(t *testing.T) {
+	server := newTestServer()
+	server.Get("/user/:id/posts/:slug", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %d %s", Param(r, "id"), Param(r, ":slug"), len(Params(r)), r.URL.RawQuery)
+	})
+
+	w := serve(server, GET, "/user/3/posts/hello?id=9")
+	if body := w.Body.String(); body != "3 hello 2 id=9" {
+		t.Errorf("Body '3 hello 2 id=9' expected, got: '%s'", body)
+	}
+
+	server.QueryParams = true
+	w = serve(server, GET, "/user/3/posts/hello")
+	if body := w.Body.String(); body != "3 hello 2 %3Aid=3&%3Aslug=hello&" {
+		t.Errorf("Body with query params expected, got: '%s'", body)
+	}
+}
+
+func TestParamConverters(t *testing.T) {
+	server := newTestServer()
+	server.AddConverter("hex", &Converter{
+		Expr: `[0-9a-f]+`,
+		Convert: func(s string) (interface{}, error) {
+			return strconv.ParseUint(s, 16, 64)
+		},
+	})
+	server.Get("/user/:id<int>", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%d", ParamInt(r, "id")+1)
+	}).Name("user.show")
+	server.Get("/user/:slug<slug>", echo("user.slug", "slug"))
+	server.Get("/item/:uuid<uuid>", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ParamUUID(r, "uuid"))
+	})
+	server.Get("/day/:date<date>", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ParamDate(r, "date").Weekday())
+	}).Name("day")
+	server.Get("/color/:c<hex>", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ParamValue(r, "c"))
+	})
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/user/41", 200, "42"},
+		{"/user/john-doe", 200, "user.slug john-doe"},
+		{"/user/99999999999999999999999", 404, ""},
+		{"/item/0F8FAD5B-D9CB-469F-A165-70867728950E", 200, "0f8fad5b-d9cb-469f-a165-70867728950e"},
+		{"/item/0f8fad5b", 404, ""},
+		{"/day/2013-07-10", 200, "Wednesday"},
+		{"/day/2013-13-45", 404, ""},
+		{"/color/ff", 200, "255"},
+	}
+	for _, test := range tests {
+		w := serve(server, GET, test.path)
+		if w.Code != test.code || (test.code == 200 && w.Body.String() != test.body) {
+			t.Errorf("%s: %d '%s' expected, got: %d '%s'", test.path, test.code, test.body, w.Code, w.Body.String())
+		}
+	}
+
+	day := time.Date(2013, 7, 10, 0, 0, 0, 0, time.UTC)
+	if u, err := server.URL("day", "date", day); err != nil || u != "/day/2013-07-10" {
+		t.Errorf("'/day/2013-07-10' expected, got: '%s' (%v)", u, err)
+	}
+	if _, err := server.URL("user.show", "id", "abc"); err == nil {
+		t.Errorf("error expected for invalid int param")
+	}
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	server := newTestServer()
+	if _, err := server.TryAddRoute(GET, "/user/:id([0-9]+", echo("bad")); err == nil {
+		t.Errorf("error expected for invalid expression")
+	}
+	if _, err := server.TryAddRoute(GET, "/user/:id<bogus>", echo("bad")); err == nil {
+		t.Errorf("error expected for unknown converter")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("AddRoute expected to panic when not validating")
+			}
+		}()
+		server.Get("/user/:id([0-9]+", echo("bad"))
+	}()
+
+	if err := server.Validate(); err != nil {
+		t.Errorf("no errors expected, got: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	server := newTestServer()
+	server.Validating = true
+	server.Get("/user/:id", echo("user.show", "id")).Name("user")
+	server.Get("/user/new", echo("user.new")).Name("user")
+	server.Get("/user/:id([0-9]+)", echo("user.id", "id"))
+	server.Get("/user/:name", echo("user.name", "name"))
+	server.Get("/user/:id/edit", echo("user.edit", "id"))
+	server.Get("/post/:id<int>", echo("post.id", "id"))
+	server.Get("/post/:slug<slug>", echo("post.slug", "slug"))
+	server.Get("/files/:file(.+", echo("files", "file"))
+
+	err := server.Validate()
+	errs, ok := err.(RouteErrors)
+	if !ok {
+		t.Fatalf("RouteErrors expected, got: %v", err)
+	}
+
+	expected := []string{
+		"GET /files/:file(.+: error parsing regexp: missing closing ): `^/files/(.+$`",
+		"GET /user/new: name user is already used by GET /user/:id",
+		"GET /user/:id([0-9]+): unreachable, /user/0 is matched by /user/:id",
+		"GET /user/:name: unreachable, /user/~ is matched by /user/:id",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("%d errors expected, got: %v", len(expected), err)
+	}
+	for i, msg := range expected {
+		if errs[i].Error() != msg {
+			t.Errorf("error '%s' expected, got: '%s'", msg, errs[i])
+		}
+	}
+}
+
+func TestHostRouting(t *testing.T) {
+	server := newTestServer()
+	server.Get("/", echo("www"))
+	server.Get("/health", echo("health"))
+	api := server.Host("api.example.com")
+	api.Get("/", echo("api"))
+	api.Group("/v1").Get("/users/:id", echo("api.user", "id"))
+	tenant := server.Host(":tenant.example.com")
+	tenant.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "tenant ", Param(r, "tenant"))
+	})
+
+	tests := []struct {
+		host, path string
+		code       int
+		body       string
+	}{
+		{"example.com", "/", 200, "www"},
+		{"api.example.com", "/", 200, "api"},
+		{"API.example.com:8080", "/v1/users/3", 200, "api.user 3"},
+		{"api.example.com", "/health", 200, "health"},
+		{"acme.example.com", "/", 200, "tenant acme"},
+		{"example.com", "/v1/users/3", 404, ""},
+		{"a.b.example.com", "/", 200, "www"},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest(GET, "http://"+test.host+test.path, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != test.code || (test.code == 200 && w.Body.String() != test.body) {
+			t.Errorf("%s%s: %d '%s' expected, got: %d '%s'", test.host, test.path, test.code, test.body, w.Code, w.Body.String())
+		}
+	}
+
+	if err := server.Validate(); err != nil {
+		t.Errorf("no errors expected, got: %v", err)
+	}
+}
+
+func TestMount(t *testing.T) {
+	admin := newTestServer()
+	admin.Get("/", echo("admin.index"))
+	admin.Get("/users/:id", echo("admin.user", "id"))
+
+	server := newTestServer()
+	server.AddFilter(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Filtered", "true")
+	})
+	server.Get("/debugger", echo("debugger"))
+	server.Mount("/admin", admin)
+	server.Mount("/debug/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Method, " ", r.URL.Path)
+	})).NoFilter()
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+		filtered     bool
+	}{
+		{GET, "/admin", 200, "admin.index", true},
+		{GET, "/admin/users/7", 200, "admin.user 7", true},
+		{POST, "/admin/users/7", 405, "", true},
+		{DELETE, "/debug/pprof/heap", 200, "DELETE /pprof/heap", false},
+		{GET, "/debug", 200, "GET /", false},
+		{GET, "/debugger", 200, "debugger", true},
+		{GET, "/debugging", 404, "", false},
+	}
+	for _, test := range tests {
+		w := serve(server, test.method, test.path)
+		if w.Code != test.code || (test.code == 200 && w.Body.String() != test.body) {
+			t.Errorf("%s %s: %d '%s' expected, got: %d '%s'", test.method, test.path, test.code, test.body, w.Code, w.Body.String())
+		}
+		if filtered := w.Header().Get("X-Filtered") == "true"; filtered != test.filtered {
+			t.Errorf("%s %s: filtered %v expected", test.method, test.path, test.filtered)
+		}
+	}
+}
+
+func TestRouteTable(t *testing.T) {
+	server := newTestServer()
+	server.Get("/user/:id([0-9]+)", echo("user.show", "id")).Name("user.show").ContentType("application/json")
+	server.Group("/admin", func(w http.ResponseWriter, r *http.Request) {}).Post("/users", echo("admin.users"))
+	server.Mount("/debug", http.NotFoundHandler()).NoFilter()
+	server.Get("/_routes", server.ServeRoutes)
+
+	table := server.RouteTable()
+	if len(table) != 4 {
+		t.Fatalf("4 routes expected, got: %d", len(table))
+	}
+	user := table[0]
+	if user.Method != GET || user.Pattern != "/user/:id([0-9]+)" || user.Regex != `^/user/([0-9]+)$` ||
+		len(user.Params) != 1 || user.Params[0] != "id" || user.Name != "user.show" || user.ContentType != "application/json" {
+		t.Errorf("unexpected route info: %+v", user)
+	}
+	if table[1].Filters != 1 || table[2].Method != ANY || !table[2].Unfiltered {
+		t.Errorf("unexpected route info: %+v %+v", table[1], table[2])
+	}
+
+	w := serve(server, GET, "/_routes?format=json")
+	if !strings.Contains(w.Body.String(), `"Pattern":"/user/:id([0-9]+)"`) {
+		t.Errorf("JSON route table expected, got: %s", w.Body.String())
+	}
+	w = serve(server, GET, "/_routes")
+	if !strings.Contains(w.Body.String(), "<td>/admin/users</td>") {
+		t.Errorf("HTML route table expected, got: %s", w.Body.String())
+	}
+}
+
+func TestCatchAllAndSlashPolicy(t *testing.T) {
+	server := newTestServer()
+	server.Get("/users", echo("users"))
+	server.Get("/posts/", echo("posts"))
+	server.Get("/files/*path", echo("files", "path")).Name("files")
+	server.Post("/form", echo("form"))
+
+	if _, err := server.TryAddRoute(GET, "/bad/*path/more", echo("bad")); err == nil {
+		t.Errorf("error expected for catch-all before the last segment")
+	}
+	if u, err := server.URL("files", "path", "css/site.css"); err != nil || u != "/files/css/site.css" {
+		t.Errorf("'/files/css/site.css' expected, got: '%s' (%v)", u, err)
+	}
+
+	tests := []struct {
+		policy       SlashPolicy
+		method, path string
+		code         int
+		body         string
+	}{
+		{SlashStrict, GET, "/files/css/site.css", 200, "files css/site.css"},
+		{SlashStrict, GET, "/files/", 404, ""},
+		{SlashStrict, GET, "/users/", 404, ""},
+		{SlashStrict, GET, "/posts", 404, ""},
+		{SlashRedirect, GET, "/users/?page=2", 301, "/users?page=2"},
+		{SlashRedirect, GET, "/posts", 301, "/posts/"},
+		{SlashRedirectPreserve, POST, "/form/", 308, "/form"},
+		{SlashMatch, GET, "/users/", 200, "users"},
+		{SlashMatch, GET, "/posts", 200, "posts"},
+		{SlashMatch, GET, "/missing/", 404, ""},
+	}
+	for _, test := range tests {
+		server.SlashPolicy = test.policy
+		w := serve(server, test.method, test.path)
+		if w.Code != test.code {
+			t.Errorf("%s %s: code %d expected, got: %d", test.method, test.path, test.code, w.Code)
+			continue
+		}
+		if test.code == 200 && w.Body.String() != test.body {
+			t.Errorf("%s %s: body '%s' expected, got: '%s'", test.method, test.path, test.body, w.Body.String())
+		}
+		if test.code > 300 && test.code < 400 && w.Header().Get("Location") != test.body {
+			t.Errorf("%s %s: location '%s' expected, got: '%s'", test.method, test.path, test.body, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "css", "style.css"), []byte("body{}"), 0644)
+
+	server := newTestServer()
+	server.Get("/", echo("index"))
+	server.Static("/", dir)
+
+	if w := serve(server, GET, "/css/style.css"); w.Code != 200 || w.Body.String() != "body{}" {
+		t.Errorf("static file expected, got: %d '%s'", w.Code, w.Body.String())
+	}
+	if w := serve(server, GET, "/"); w.Body.String() != "index" {
+		t.Errorf("index expected, got: '%s'", w.Body.String())
+	}
+}
+
+// appends name to the X-Trace header before and after next
+func trace(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+			w.Header().Add("X-Trace", "/"+name)
+		})
+	}
+}
+
+// upperWriter replaces the ResponseWriter, uppercasing the body
+type upperWriter struct {
+	http.ResponseWriter
+}
+
+func (this upperWriter) Write(p []byte) (int, error) {
+	return this.ResponseWriter.Write([]byte(strings.ToUpper(string(p))))
+}
+
+func TestMiddleware(t *testing.T) {
+	server := newTestServer()
+	server.Use(trace("a"), trace("b"))
+	server.AddFilter(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Trace", "filter")
+	})
+	server.Get("/", echo("index")).Use(trace("route"), func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(upperWriter{w}, r)
+		})
+	})
+	api := server.Group("/api")
+	api.Use(Filter(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	api.Get("/secret", echo("secret"))
+
+	w := serve(server, GET, "/")
+	if body := w.Body.String(); body != "INDEX" {
+		t.Errorf("Body 'INDEX' expected, got: '%s'", body)
+	}
+	if trace := strings.Join(w.Header()["X-Trace"], ","); trace != "a,b,filter,route,/route,/b,/a" {
+		t.Errorf("Trace 'a,b,filter,route,/route,/b,/a' expected, got: '%s'", trace)
+	}
+
+	if w = serve(server, GET, "/api/secret"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Code 401 expected, got: %d", w.Code)
+	}
+	r, _ := http.NewRequest(GET, "/api/secret", nil)
+	r.Header.Set("Authorization", "yes")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if w.Body.String() != "secret" {
+		t.Errorf("Body 'secret' expected, got: '%s'", w.Body.String())
+	}
+
+	// NoFilter after serving rebuilds the chain
+	index := server.Routes[0]
+	index.NoFilter()
+	if trace := strings.Join(serve(server, GET, "/").Header()["X-Trace"], ","); trace != "a,b,route,/route,/b,/a" {
+		t.Errorf("Trace 'a,b,route,/route,/b,/a' expected after NoFilter, got: '%s'", trace)
+	}
+}
+
+func TestRouteFilters(t *testing.T) {
+	server := newTestServer()
+	var order []string
+	server.AddFilter(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "global")
+	})
+
+	auth := func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "auth")
+		if r.Header.Get("Authorization") != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+	}
+	route := server.Get("/admin", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+		w.Write([]byte("admin"))
+	})
+	route.AddFilter(auth)
+	route.AddFilter(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "second")
+	})
+	server.Get("/public", echo("public"))
+
+	for i := 0; i < 20; i++ {
+		order = nil
+		w := serve(server, GET, "/admin")
+		if w.Code != http.StatusUnauthorized || w.Body.String() != "Unauthorized\n" {
+			t.Fatalf("401 expected, got: %d '%s'", w.Code, w.Body.String())
+		}
+		if trace := strings.Join(order, ","); trace != "global,auth" {
+			t.Fatalf("filters 'global,auth' expected, got: '%s'", trace)
+		}
+	}
+
+	order = nil
+	r, _ := http.NewRequest(GET, "/admin", nil)
+	r.Header.Set("Authorization", "secret")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if w.Code != 200 || w.Body.String() != "admin" {
+		t.Errorf("200 'admin' expected, got: %d '%s'", w.Code, w.Body.String())
+	}
+	if trace := strings.Join(order, ","); trace != "global,auth,second,handler" {
+		t.Errorf("filters 'global,auth,second,handler' expected, got: '%s'", trace)
+	}
+
+	if w := serve(server, GET, "/public"); w.Body.String() != "public" {
+		t.Errorf("route filters expected only on their route, got: '%s'", w.Body.String())
+	}
+}
+
+func TestAfterFilters(t *testing.T) {
+	server := newTestServer()
+	var res *Response
+	var order []string
+	server.AfterFilter(func(w http.ResponseWriter, r *http.Request, response *Response) {
+		order = append(order, "global")
+		res = response
+	})
+	server.OnResponse(func(w http.ResponseWriter, r *http.Request, status int) {
+		if status >= 400 {
+			w.Header().Set("X-Error", "yes")
+		}
+	})
+
+	server.Get("/ok", echo("hello")).AfterFilter(func(w http.ResponseWriter, r *http.Request, response *Response) {
+		order = append(order, "route")
+	})
+	server.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	w := serve(server, GET, "/ok")
+	if res == nil || res.Status != 200 || res.Size != 5 || res.Panicked {
+		t.Fatalf("200, 5 bytes expected, got: %+v", res)
+	}
+	if trace := strings.Join(order, ","); trace != "route,global" {
+		t.Errorf("after filters 'route,global' expected, got: '%s'", trace)
+	}
+	if w.Header().Get("X-Error") != "" {
+		t.Errorf("no X-Error header expected on 200")
+	}
+
+	if w := serve(server, GET, "/missing"); res.Status != 404 || w.Header().Get("X-Error") != "yes" {
+		t.Errorf("404 with X-Error header expected, got: %d '%s'", res.Status, w.Header().Get("X-Error"))
+	}
+
+	res = nil
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("panic expected to propagate")
+			}
+		}()
+		serve(server, GET, "/panic")
+	}()
+	if res == nil || !res.Panicked || res.Status != 500 || res.Duration <= 0 {
+		t.Errorf("panicked 500 expected, got: %+v", res)
+	}
+}
+
+func timedPanic(w http.ResponseWriter, r *http.Request) {
+	panic("timed boom")
+}
+
+func TestRecoverPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "500.html")
+	ioutil.WriteFile(file, []byte("<h1>{{.Status}} {{.StatusText}}</h1>"), 0644)
+
+	var logs bytes.Buffer
+	server := newTestServer()
+	server.Logger = log.New(&logs, "", 0)
+	server.Config = &ServerConfig{RecoverPanic: true, ErrorTemplate: file}
+
+	var reported interface{}
+	server.PanicReporter = PanicReporterFunc(func(r *http.Request, err interface{}, stack []byte) {
+		reported = err
+	})
+	var res *Response
+	server.AfterFilter(func(w http.ResponseWriter, r *http.Request, response *Response) {
+		res = response
+	})
+	server.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	w := serve(server, GET, "/panic")
+	if w.Code != 500 || w.Body.String() != "<h1>500 Internal Server Error</h1>" {
+		t.Errorf("500 error page expected, got: %d '%s'", w.Code, w.Body.String())
+	}
+	if reported != "boom" {
+		t.Errorf("panic 'boom' expected to be reported, got: %v", reported)
+	}
+	if !strings.Contains(logs.String(), "panic serving GET /panic: boom") ||
+		!strings.Contains(logs.String(), "goroutine") {
+		t.Errorf("panic and stack trace expected in log, got: '%s'", logs.String())
+	}
+	if res == nil || !res.Panicked || res.Status != 500 {
+		t.Errorf("panicked 500 expected in after filters, got: %+v", res)
+	}
+
+	// the stack is the handler's, even when it runs on its own goroutine
+	var stack []byte
+	server.PanicReporter = PanicReporterFunc(func(r *http.Request, err interface{}, s []byte) {
+		reported, stack = err, s
+	})
+	server.Get("/timed", timedPanic).Timeout(time.Second)
+	if w := serve(server, GET, "/timed"); w.Code != 500 || reported != "timed boom" {
+		t.Errorf("500 and reported panic expected, got: %d %v", w.Code, reported)
+	}
+	if !strings.Contains(string(stack), "plate.timedPanic") {
+		t.Errorf("stack of the handler expected, got: %s", stack)
+	}
+
+	// gzip must not write its stream for a panicking handler
+	for _, path := range []string{"/panic", "/timed"} {
+		r, _ := http.NewRequest(GET, path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != 500 || w.Header().Get("Content-Encoding") != "" || w.Body.String() != "<h1>500 Internal Server Error</h1>" {
+			t.Errorf("%s: plain 500 error page expected with gzip, got: %d %v '%s'", path, w.Code, w.Header(), w.Body.String())
+		}
+	}
+
+	server.Config.ErrorTemplate = filepath.Join(dir, "missing.html")
+	if w := serve(server, GET, "/panic"); w.Code != 500 || w.Body.String() != "Internal Server Error\n" {
+		t.Errorf("plain 500 expected without a template, got: %d '%s'", w.Code, w.Body.String())
+	}
+}
+
+func TestCors(t *testing.T) {
+	server := newTestServer()
+	server.Use(Cors(CorsOptions{
+		Origins:        []string{"https://example.com", "https://*.example.org"},
+		Methods:        []string{GET, POST},
+		Headers:        []string{"X-Token"},
+		ExposedHeaders: []string{"X-Total"},
+		Credentials:    true,
+		MaxAge:         10 * time.Minute,
+	}))
+	server.Get("/items", echo("items"))
+	server.Post("/items", echo("created"))
+
+	request := func(method, origin string, header ...string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, "/items", nil)
+		r.Header.Set("Origin", origin)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request(GET, "https://api.example.org")
+	if w.Body.String() != "items" || w.Header().Get("Access-Control-Allow-Origin") != "https://api.example.org" {
+		t.Errorf("origin expected to be allowed, got: '%s'", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Access-Control-Expose-Headers") != "X-Total" {
+		t.Errorf("credentials and exposed headers expected, got: %v", w.Header())
+	}
+	if w.Header().Get("Vary") != "Origin" {
+		t.Errorf("Vary: Origin expected, got: '%s'", w.Header().Get("Vary"))
+	}
+
+	for _, origin := range []string{"https://evil.com", "https://example.org", "http://api.example.org"} {
+		if w := request(GET, origin); w.Body.String() != "items" || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s expected to be served without CORS headers, got: %v", origin, w.Header())
+		}
+	}
+
+	w = request(OPTIONS, "https://example.com",
+		"Access-Control-Request-Method", POST,
+		"Access-Control-Request-Headers", "content-type, x-token")
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("204 expected for preflight, got: %d '%s'", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") != "GET, POST" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "content-type, x-token" ||
+		w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("preflight headers expected, got: %v", w.Header())
+	}
+
+	w = request(OPTIONS, "https://example.com", "Access-Control-Request-Method", DELETE)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight for DELETE expected to be refused, got: %v", w.Header())
+	}
+
+	if w := request(OPTIONS, "https://example.com"); w.Code != 200 || w.Header().Get("Allow") == "" {
+		t.Errorf("plain OPTIONS expected to reach the server, got: %d %v", w.Code, w.Header())
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("panic expected for credentials from any origin")
+			}
+		}()
+		Cors(CorsOptions{Origins: []string{"*"}, Credentials: true})
+	}()
+
+	any := newTestServer()
+	any.Use(Cors(CorsOptions{Origins: []string{"*"}}))
+	any.Get("/items", echo("items"))
+	r, _ := http.NewRequest(GET, "/items", nil)
+	r.Header.Set("Origin", "https://evil.com")
+	w = httptest.NewRecorder()
+	any.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("'*' without credentials expected for any origin, got: %v", w.Header())
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	server := newTestServer()
+	handler := server.NewSessionHandler("secret", &RequestSessions{})
+	server.Use(CSRF(handler.RS))
+
+	server.Get("/form", func(w http.ResponseWriter, r *http.Request) {
+		tmpl, _ := server.Template(w, r)
+		tmpl.HtmlTemplate = template.Must(template.New("form").Funcs(tmpl.FuncMap).
+			Parse(`<form action="{{.Request.URL.Path}}">{{csrfField}}</form>`))
+		tmpl.HtmlTemplate.Execute(w, tmpl.Bag)
+	})
+	server.Post("/form", echo("saved"))
+	server.Post("/api", echo("api")).NoCSRF()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(GET, "/form", nil))
+	cookies := w.Result().Cookies()
+	match := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(w.Body.String())
+	if len(cookies) != 1 || match == nil || !strings.Contains(w.Body.String(), `action="/form"`) {
+		t.Fatalf("session cookie and token expected, got: %v '%s'", cookies, w.Body.String())
+	}
+	token := match[1]
+
+	post := func(path string, body string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(POST, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookies[0])
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := post("/form", "csrf_token="+token); w.Code != 200 || w.Body.String() != "saved" {
+		t.Errorf("form token expected to be accepted, got: %d '%s'", w.Code, w.Body.String())
+	}
+	if w := post("/form", "", CSRFHeader, token); w.Code != 200 {
+		t.Errorf("header token expected to be accepted, got: %d '%s'", w.Code, w.Body.String())
+	}
+	if w := post("/form", "csrf_token=forged"); w.Code != http.StatusForbidden {
+		t.Errorf("403 expected for a forged token, got: %d", w.Code)
+	}
+	if w := post("/form", ""); w.Code != http.StatusForbidden {
+		t.Errorf("403 expected for a missing token, got: %d", w.Code)
+	}
+	if w := post("/api", ""); w.Code != 200 || w.Body.String() != "api" {
+		t.Errorf("NoCSRF route expected to skip the check, got: %d '%s'", w.Code, w.Body.String())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server := newTestServer()
+	server.Post("/login", echo("ok")).Use(server.RateLimit(RateLimit{
+		Limit:  3,
+		Window: time.Minute,
+	}))
+	server.Get("/api", echo("api")).Use(server.RateLimit(RateLimit{
+		Limit:  1,
+		Window: time.Minute,
+		Key:    KeyByHeader("X-API-Key"),
+	}))
+
+	login := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(POST, "/login", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		w := login("10.0.0.1:1234")
+		if w.Code != 200 || w.Header().Get("RateLimit-Remaining") != strconv.Itoa(2-i) {
+			t.Fatalf("request %d expected to be allowed, got: %d %v", i, w.Code, w.Header())
+		}
+	}
+	w := login("10.0.0.1:5678")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "20" ||
+		w.Header().Get("RateLimit-Limit") != "3" || w.Header().Get("RateLimit-Reset") != "60" {
+		t.Errorf("429 expected, got: %d %v", w.Code, w.Header())
+	}
+	if w := login("10.0.0.2:1234"); w.Code != 200 {
+		t.Errorf("other IPs expected to have their own limit, got: %d", w.Code)
+	}
+	if server.StatusService.RateLimited != 1 {
+		t.Errorf("1 rejection expected, got: %d", server.StatusService.RateLimited)
+	}
+
+	api := func(key string) int {
+		r := httptest.NewRequest(GET, "/api", nil)
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+	if api("a") != 200 || api("a") != 429 || api("b") != 200 {
+		t.Errorf("limits expected per API key")
+	}
+
+	for _, limit := range []RateLimit{{Limit: 0, Window: time.Minute}, {Limit: 1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("panic expected for %+v", limit)
+				}
+			}()
+			server.RateLimit(limit)
+		}()
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	server := newTestServer()
+	server.Logging = true
+	server.Logger = log.New(&logs, "", 0)
+
+	var seen string
+	server.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r)
+	})
+
+	w := serve(server, GET, "/")
+	if len(seen) != 32 || w.Header().Get(RequestIDHeader) != seen {
+		t.Errorf("generated id expected in context and response, got: '%s' '%s'", seen, w.Header().Get(RequestIDHeader))
+	}
+	if !strings.HasSuffix(strings.TrimSpace(logs.String()), " "+seen) {
+		t.Errorf("id expected in access log, got: '%s'", logs.String())
+	}
+
+	for id, expected := range map[string]bool{"abc-123": true, "bad id": false, `"quoted"`: false} {
+		r, _ := http.NewRequest(GET, "/", nil)
+		r.Header.Set(RequestIDHeader, id)
+		server.ServeHTTP(httptest.NewRecorder(), r)
+		if (seen == id) != expected {
+			t.Errorf("id '%s' accepted expected %v, got: '%s'", id, expected, seen)
+		}
+	}
+
+	// a mounted server keeps the id of the one it is mounted on
+	var outerID string
+	outer := newTestServer()
+	outer.Mount("/app", server).Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			outerID = RequestID(r)
+			next.ServeHTTP(w, r)
+		})
+	})
+	w = serve(outer, GET, "/app/")
+	if len(outerID) != 32 || seen != outerID || w.Header().Get(RequestIDHeader) != outerID {
+		t.Errorf("outer id '%s' expected in the mounted server and response, got: '%s' '%s'", outerID, seen, w.Header().Get(RequestIDHeader))
+	}
+	if !strings.HasSuffix(strings.TrimSpace(logs.String()), " "+seen) {
+		t.Errorf("outer id expected in the mounted server's access log, got: '%s'", logs.String())
+	}
+}
+
+func TestAuth(t *testing.T) {
+	server := newTestServer()
+	whoami := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, Principal(r))
+	}
+	server.Get("/basic", whoami).Use(BasicAuth("admin", BasicUsers(map[string]string{"ann": "s3cret"})))
+	server.Get("/bearer", whoami).Use(BearerAuth(Tokens(map[string]interface{}{"tok": "bob"})))
+	server.Get("/key", whoami).Use(APIKeyAuth("X-API-Key", "api_key", Tokens(map[string]interface{}{"k1": "carl"})))
+	server.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		tmpl, _ := server.Template(w, r)
+		tmpl.HtmlTemplate = template.Must(template.New("hello").Funcs(tmpl.FuncMap).
+			Parse(`{{with principal}}Hello {{.}}{{end}}`))
+		tmpl.HtmlTemplate.Execute(w, tmpl.Bag)
+	}).Use(BearerAuth(Tokens(map[string]interface{}{"tok": "bob"})))
+
+	tests := []struct {
+		path, header, value string
+		code                int
+		body                string
+	}{
+		{"/basic", "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte("ann:s3cret")), 200, "ann"},
+		{"/basic", "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte("ann:wrong")), 401, "Unauthorized\n"},
+		{"/basic", "", "", 401, "Unauthorized\n"},
+		{"/bearer", "Authorization", "Bearer tok", 200, "bob"},
+		{"/bearer", "Authorization", "bearer tok", 200, "bob"},
+		{"/bearer", "Authorization", "Bearer nope", 401, "Unauthorized\n"},
+		{"/key", "X-API-Key", "k1", 200, "carl"},
+		{"/key?api_key=k1", "", "", 200, "carl"},
+		{"/key?api_key=k2", "", "", 401, "Unauthorized\n"},
+		{"/hello", "Authorization", "Bearer tok", 200, "Hello bob"},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest(GET, test.path, nil)
+		if len(test.header) > 0 {
+			r.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != test.code || w.Body.String() != test.body {
+			t.Errorf("%s %s: %d '%s' expected, got: %d '%s'", test.path, test.value, test.code, test.body, w.Code, w.Body.String())
+		}
+	}
+
+	if w := serve(server, GET, "/basic"); w.Header().Get("WWW-Authenticate") != `Basic realm="admin"` {
+		t.Errorf("Basic challenge expected, got: '%s'", w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+type testUser struct {
+	name  string
+	roles []string
+}
+
+func (this testUser) Roles() []string { return this.roles }
+
+func TestRequire(t *testing.T) {
+	server := newTestServer()
+	server.Logger = log.New(ioutil.Discard, "", 0)
+	server.Get("/login", echo("login")).Name("authz-login")
+	server.LoginRoute = "authz-login"
+
+	var denied []string
+	server.OnDeny(func(r *http.Request, principal interface{}, requirements []string) {
+		denied = append(denied, r.URL.Path)
+	})
+
+	auth := BearerAuth(Tokens(map[string]interface{}{
+		"admin": testUser{"ann", []string{"admin", "staff"}},
+		"staff": testUser{"bob", []string{"staff"}},
+	}))
+	server.Get("/admin", echo("admin")).Use(auth).Require("admin")
+	staff := server.Group("/staff")
+	staff.Use(auth)
+	staff.Require("staff")
+	staff.Get("/reports", echo("reports"))
+	server.Get("/dashboard", echo("dashboard")).Require("admin")
+	// routes added before Require, in the group and a nested one
+	ops := server.Group("/ops")
+	ops.Use(auth)
+	ops.Get("/jobs", echo("jobs"))
+	ops.Group("/queues").Get("/mail", echo("mail"))
+	ops.Require("admin")
+
+	tests := []struct {
+		path, token, accept string
+		code                int
+	}{
+		{"/admin", "admin", "", 200},
+		{"/admin", "staff", "", 403},
+		{"/staff/reports", "staff", "", 200},
+		{"/staff/reports", "admin", "", 200},
+		{"/dashboard", "", "text/html,*/*", 302},
+		{"/dashboard", "", "application/json", 403},
+		{"/ops/jobs", "admin", "", 200},
+		{"/ops/jobs", "staff", "", 403},
+		{"/ops/queues/mail", "admin", "", 200},
+		{"/ops/queues/mail", "staff", "", 403},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest(GET, test.path, nil)
+		if len(test.token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s as %s: %d expected, got: %d", test.path, test.token, test.code, w.Code)
+		}
+		if w.Code == 302 && w.Header().Get("Location") != "/login?next=%2Fdashboard" {
+			t.Errorf("redirect to login expected, got: '%s'", w.Header().Get("Location"))
+		}
+	}
+	if strings.Join(denied, ",") != "/admin,/dashboard,/dashboard,/ops/jobs,/ops/queues/mail" {
+		t.Errorf("denials expected in the deny hook, got: %v", denied)
+	}
+}
+
+func TestMaxBody(t *testing.T) {
+	server := newTestServer()
+	server.MaxBody = 16
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var v map[string]string
+		if err := ReadJson(r, &v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(v["a"]))
+	}
+	server.Post("/small", handler)
+	server.Post("/large", handler).MaxBody(1024)
+	server.Post("/unlimited", handler).MaxBody(-1)
+
+	post := func(path, body string, length int64, encoding ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(POST, path, strings.NewReader(body))
+		r.ContentLength = length
+		if len(encoding) > 0 {
+			r.Header.Set("Accept-Encoding", encoding[0])
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	small := `{"a":"b"}`
+	large := `{"a":"` + strings.Repeat("x", 100) + `"}`
+	tests := []struct {
+		path, body string
+		length     int64
+		code       int
+	}{
+		{"/small", small, int64(len(small)), 200},
+		{"/small", large, int64(len(large)), 413},
+		{"/small", large, -1, 413},
+		{"/large", large, int64(len(large)), 200},
+		{"/unlimited", large, -1, 200},
+	}
+	for _, test := range tests {
+		w := post(test.path, test.body, test.length)
+		if w.Code != test.code {
+			t.Errorf("%s %d bytes: %d expected, got: %d '%s'", test.path, len(test.body), test.code, w.Code, w.Body.String())
+		}
+		if w.Code == 413 && w.Body.String() != `{"error":"Request body too large","limit":16}` {
+			t.Errorf("structured 413 expected, got: '%s'", w.Body.String())
+		}
+	}
+
+	// the 413 is never gzipped, even though the handler's response
+	// was going to be, and the body is chunked so only reading tells
+	w := post("/small", large, -1, "gzip")
+	if w.Code != 413 || w.Header().Get("Content-Encoding") != "" ||
+		w.Body.String() != `{"error":"Request body too large","limit":16}` {
+		t.Errorf("plain 413 expected, got: %d %v '%s'", w.Code, w.Header(), w.Body.String())
+	}
+	if w := post("/small", small, -1, "gzip"); w.Code != 200 || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("gzipped 200 expected within the limit, got: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestBodyTimeout(t *testing.T) {
+	server := newTestServer()
+	server.BodyTimeout = 50 * time.Millisecond
+	server.Timeout = 2 * time.Second
+
+	// the deadline covers the body, never the handler's work after it
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		time.Sleep(150 * time.Millisecond)
+		if err != nil || r.Context().Err() != nil {
+			http.Error(w, fmt.Sprint(err, r.Context().Err()), http.StatusInternalServerError)
+			return
+		}
+		w.Write(body)
+	}
+	server.Get("/work", slow)
+	server.Post("/work", slow)
+
+	read := make(chan error, 1)
+	server.Post("/upload", func(w http.ResponseWriter, r *http.Request) {
+		_, err := ioutil.ReadAll(r.Body)
+		read <- err
+	})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	for method, body := range map[string]string{GET: "", POST: "body"} {
+		r, _ := http.NewRequest(method, ts.URL+"/work", strings.NewReader(body))
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 || string(content) != body {
+			t.Errorf("%s: 200 expected after the body timeout, got: %d '%s'", method, res.StatusCode, content)
+		}
+	}
+
+	// a body that stalls still fails to read
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n\r\nstalled")
+	select {
+	case err := <-read:
+		if err == nil {
+			t.Errorf("read error expected for a stalled body")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("stalled body expected to time out")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := newTestServer()
+	server.Timeout = 20 * time.Millisecond
+	server.TimeoutBody = "Try again later"
+
+	late := make(chan error, 1)
+	server.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(5 * time.Millisecond)
+		w.Header().Set("X-Late", "yes")
+		_, err := w.Write([]byte("late"))
+		late <- err
+	})
+	server.Get("/fast", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := Deadline(r); !ok {
+			t.Errorf("deadline expected in context")
+		}
+		w.Write([]byte("fast"))
+	})
+	server.Get("/patient", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(40 * time.Millisecond)
+		w.Write([]byte("patient"))
+	}).Timeout(time.Second)
+
+	w := serve(server, GET, "/slow")
+	if w.Code != 503 || w.Body.String() != "Try again later\n" {
+		t.Errorf("503 expected, got: %d '%s'", w.Code, w.Body.String())
+	}
+	if err := <-late; err != http.ErrHandlerTimeout || w.Header().Get("X-Late") != "" {
+		t.Errorf("late writes expected to be dropped, got: %v %v", err, w.Header())
+	}
+
+	// nothing waits for a panic after the timeout, so the
+	// handler's goroutine reports it
+	var logs bytes.Buffer
+	server.Logger = log.New(&logs, "", 0)
+	reported := make(chan interface{}, 1)
+	server.PanicReporter = PanicReporterFunc(func(r *http.Request, err interface{}, stack []byte) {
+		reported <- err
+	})
+	server.Get("/late-panic", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(5 * time.Millisecond)
+		panic("late boom")
+	})
+	if w := serve(server, GET, "/late-panic"); w.Code != 503 {
+		t.Errorf("503 expected, got: %d", w.Code)
+	}
+	select {
+	case err := <-reported:
+		if err != "late boom" || !strings.Contains(logs.String(), "panic serving GET /late-panic: late boom") {
+			t.Errorf("late panic expected to be logged and reported, got: %v '%s'", err, logs.String())
+		}
+	case <-time.After(time.Second):
+		t.Errorf("late panic expected to be reported")
+	}
+
+	if w := serve(server, GET, "/fast"); w.Code != 200 || w.Body.String() != "fast" {
+		t.Errorf("200 'fast' expected, got: %d '%s'", w.Code, w.Body.String())
+	}
+	if w := serve(server, GET, "/patient"); w.Code != 200 || w.Body.String() != "patient" {
+		t.Errorf("route timeout expected to override, got: %d '%s'", w.Code, w.Body.String())
+	}
+
+	// reading past the body limit after the timeout must not touch
+	// the response, run with -race
+	server.MaxBody = 10
+	read := make(chan error, 1)
+	server.Post("/upload", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(40 * time.Millisecond)
+		var v map[string]string
+		err := ReadJson(r, &v)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		read <- err
+	}).Timeout(10 * time.Millisecond)
+
+	r := httptest.NewRequest(POST, "/upload", strings.NewReader(`{"a":"`+strings.Repeat("x", 100)+`"}`))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if err := <-read; err != BodyLimitError || w.Code != 503 || w.Body.String() != "Try again later\n" {
+		t.Errorf("503 expected, got: %d '%s' after %v", w.Code, w.Body.String(), err)
+	}
+}
+
+func TestETags(t *testing.T) {
+	server := newTestServer()
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	renders := 0
+	server.Get("/doc", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		ServeJson(w, map[string]int{"a": 1})
+	}).Use(ETags(false))
+	server.Get("/weak", echo("weak")).Use(ETags(true))
+	server.Get("/post", func(w http.ResponseWriter, r *http.Request) {
+		if CheckETag(w, r, "42") {
+			return
+		}
+		renders++
+		w.Write([]byte("post"))
+	})
+
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(GET, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/doc")
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || w.Body.String() != `{"a":1}` || !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("200 with a strong ETag expected, got: %d '%s' '%s'", w.Code, w.Body.String(), etag)
+	}
+	if w := get("/doc", "If-None-Match", `"other", `+etag); w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("304 expected for a matching ETag, got: %d '%s'", w.Code, w.Body.String())
+	}
+	if w := get("/doc", "If-None-Match", `"other"`); w.Code != 200 {
+		t.Errorf("200 expected for a different ETag, got: %d", w.Code)
+	}
+	if w := get("/doc", "If-Modified-Since", modified.Format(http.TimeFormat)); w.Code != 304 {
+		t.Errorf("304 expected when not modified since, got: %d", w.Code)
+	}
+	if w := get("/doc", "If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat)); w.Code != 200 {
+		t.Errorf("200 expected when modified since, got: %d", w.Code)
+	}
+
+	w = get("/weak")
+	if !strings.HasPrefix(w.Header().Get("ETag"), `W/"`) {
+		t.Errorf("weak ETag expected, got: '%s'", w.Header().Get("ETag"))
+	}
+	if w := get("/weak", "If-None-Match", strings.TrimPrefix(w.Header().Get("ETag"), "W/")); w.Code != 304 {
+		t.Errorf("weak comparison expected, got: %d", w.Code)
+	}
+
+	if w := get("/post"); w.Code != 200 || w.Header().Get("ETag") != `W/"42"` || renders != 1 {
+		t.Errorf("versioned ETag expected, got: %d '%s'", w.Code, w.Header().Get("ETag"))
+	}
+	if w := get("/post", "If-None-Match", `W/"42"`); w.Code != 304 || renders != 1 {
+		t.Errorf("304 expected without rendering, got: %d after %d renders", w.Code, renders)
+	}
+}
@@ -3,6 +3,7 @@ package plate
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
@@ -66,6 +67,11 @@ func (rs *RequestSessions) Get(req *http.Request) map[string]interface{} {
 		return nil
 	}
 
+	// the server hands handlers copies of the request carrying
+	// route params, so look up the one the session was set for
+	if orig, ok := req.Context().Value(sessionKey).(*http.Request); ok {
+		req = orig
+	}
 	return rs.m[req]
 }
 
@@ -318,7 +324,7 @@ func (h *SessionHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	defer h.RS.Clear(req)
 
 	sessionWriter := sessionResponseWriter{rw, h, req, 0}
-	h.Handler.ServeHTTP(sessionWriter, req)
+	h.Handler.ServeHTTP(sessionWriter, req.WithContext(context.WithValue(req.Context(), sessionKey, req)))
 }
 
 func (this *Server) NewSessionHandler(key string, rs *RequestSessions) *SessionHandler {
@@ -346,3 +352,13 @@ func (this *Server) NewSessionHandler(key string, rs *RequestSessions) *SessionH
 		hmacKey:    hmacHash.Sum(nil)[:blockSize],
 	}
 }
+
+// DeriveKey returns a 32 byte key for purpose, derived from the
+// session key, so other signers like jwt can share the secret
+// without reusing the session's own keys
+func (h *SessionHandler) DeriveKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, h.hmacKey)
+	mac.Write([]byte("plate-derive-"))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,47 @@
+package plate
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SlashPolicy decides how a request path differing from a route
+// only by its trailing slash, ie /users/ for /users, is handled
+type SlashPolicy int
+
+const (
+	SlashStrict           SlashPolicy = iota // the paths are distinct, the default
+	SlashRedirect                            // 301 redirect to the registered form
+	SlashRedirectPreserve                    // 308 redirect, keeping the method and body
+	SlashMatch                               // serve either form
+)
+
+// find matches the request to a route, trying the path with its
+// trailing slash added or removed when the SlashPolicy allows it.
+// It returns the path that was matched.
+func (this *Server) find(rt *router, method, host, path string) (*Route, []string, map[string]string, string) {
+	route, values, hostParams := rt.match(method, host, path)
+	if route != nil || this.SlashPolicy == SlashStrict || path == "/" || len(path) == 0 {
+		return route, values, hostParams, path
+	}
+
+	alt := path + "/"
+	if strings.HasSuffix(path, "/") {
+		alt = path[:len(path)-1]
+	}
+	route, values, hostParams = rt.match(method, host, alt)
+	return route, values, hostParams, alt
+}
+
+// redirectSlash redirects the request to path, the canonical
+// form of its path, keeping the query string
+func (this *Server) redirectSlash(w http.ResponseWriter, r *http.Request, path string) {
+	code := http.StatusMovedPermanently
+	if this.SlashPolicy == SlashRedirectPreserve {
+		code = http.StatusPermanentRedirect
+	}
+	if len(r.URL.RawQuery) > 0 {
+		path += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, path, code)
+}
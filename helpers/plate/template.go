@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 )
 
 var (
@@ -24,15 +25,44 @@ type Template struct {
 /* Templating |-- Using html/template library built into golang http://golang.org/pkg/html/template/ --|
    ------------------------------ */
 
-func (this *Server) Template(w http.ResponseWriter) (templ *Template, err error) {
+// Template returns a Template writing to w. When the request being
+// served is given, it is put in the Bag as "Request" and the csrfField
+// and principal funcs render its CSRF token and principal, ie:
+//
+//	tmpl, _ := server.Template(w, r)
+//
+//	<form method="post">{{csrfField}} ...</form>
+//	{{with principal}}Signed in as {{.}}{{end}}
+func (this *Server) Template(w http.ResponseWriter, r ...*http.Request) (templ *Template, err error) {
 	if w == nil {
 		log.Printf("Template Error: %v", err.Error())
 		return
 	}
+	var req *http.Request
+	if len(r) > 0 {
+		req = r[0]
+	}
 	templ = &Template{
-		Writer:  w,
-		Bag:     make(map[string]interface{}),
-		FuncMap: template.FuncMap{},
+		Writer: w,
+		Bag:    make(map[string]interface{}),
+		FuncMap: template.FuncMap{
+			"url": this.URL,
+			"csrfField": func() template.HTML {
+				if req == nil {
+					return ""
+				}
+				return CSRFField(req)
+			},
+			"principal": func() interface{} {
+				if req == nil {
+					return nil
+				}
+				return Principal(req)
+			},
+		},
+	}
+	if req != nil {
+		templ.Bag["Request"] = req
 	}
 
 	return
@@ -52,11 +82,12 @@ func (t Template) SinglePage(file_path string) (err error) {
 		t.Template = dir + "/" + file_path
 	}
 
-	tmpl, err := template.ParseFiles(t.Template)
+	// funcs must be added before parsing, or the parser
+	// will reject templates that call them
+	tmpl, err := template.New(filepath.Base(t.Template)).Funcs(t.FuncMap).ParseFiles(t.Template)
 	if err != nil {
 		return err
 	}
-	tmpl.Funcs(t.FuncMap)
 	err = tmpl.Execute(t.Writer, t.Bag)
 
 	return
@@ -80,11 +111,10 @@ func (t Template) DisplayTemplate() (err error) {
 		t.Bag = make(map[string]interface{})
 	}
 
-	templ, err := template.ParseFiles(t.Layout, t.Template)
+	templ, err := template.New(filepath.Base(t.Layout)).Funcs(t.FuncMap).ParseFiles(t.Layout, t.Template)
 	if err != nil {
 		return err
 	}
-	templ.Funcs(t.FuncMap)
 
 	err = templ.Execute(t.Writer, t.Bag)
 
@@ -101,14 +131,10 @@ func (t Template) DisplayMultiple(templates []string) (err error) {
 	if t.Layout == "" {
 		t.Layout = "layout.html"
 	}
-	// ensure proper pathing for layout layout files
-	t.Layout = t.Layout
-
-	templ, err := template.ParseFiles(dir + "/" + t.Layout)
+	templ, err := template.New(filepath.Base(t.Layout)).Funcs(t.FuncMap).ParseFiles(dir + "/" + t.Layout)
 	if err != nil {
 		return err
 	}
-	templ.Funcs(t.FuncMap)
 	for _, filename := range templates {
 		_, err = templ.ParseFiles(dir + "/" + filename)
 	}
@@ -168,13 +194,12 @@ func (t Template) Display(w http.ResponseWriter) error {
 			return err
 		}
 
-		tmpl, err := template.ParseFiles(dir + "/" + t.Template)
+		tmpl, err := template.New(filepath.Base(t.Template)).Funcs(t.FuncMap).ParseFiles(dir + "/" + t.Template)
 
 		if err != nil {
 			log.Println(err)
 			return err
 		}
-		tmpl.Funcs(t.FuncMap)
 
 		t.HtmlTemplate = tmpl
 		return nil
@@ -192,12 +217,20 @@ func GetTemplate() (*Template, error) {
 	return nil, errors.New("No template defined")
 }
 
-func NewTemplate(w http.ResponseWriter) *Template {
-	server := NewServer()
-
+// NewTemplate returns the template set by SetTemplate, or a new
+// Template, given the request as in Server.Template. The `url` func
+// builds URLs from the route names of the server that matched the
+// request, so r is needed to link to named routes
+func NewTemplate(w http.ResponseWriter, r ...*http.Request) *Template {
 	tmpl, err := GetTemplate()
 	if err != nil {
-		tmpl, err = server.Template(w)
+		server := mainServer
+		if len(r) > 0 && r[0] != nil {
+			if route := matched(r[0]); route != nil && route.server != nil {
+				server = route.server
+			}
+		}
+		tmpl, err = server.Template(w, r...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return tmpl
@@ -0,0 +1,160 @@
+package plate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Limits the time the route's handler may take, overriding the
+// server's Timeout. A negative d removes the limit.
+//
+// A handler still running at the deadline carries on in the
+// background, and must not touch anything the request uses after
+// the 503 is sent: the session, which is saved when the response
+// is written, nor any shared state not guarded by a lock. Its
+// writes are dropped, and a panic is only logged and reported
+func (this *Route) Timeout(d time.Duration) *Route {
+	this.timeout = d
+	return this
+}
+
+// Deadline returns the time by which the request must be answered,
+// for passing on to slow calls like database queries. ok is false
+// when there is no deadline
+func Deadline(r *http.Request) (deadline time.Time, ok bool) {
+	return r.Context().Deadline()
+}
+
+// timeout returns the timeout of the route, or 0 for none
+func (this *Server) timeout(route *Route) time.Duration {
+	if route.timeout != 0 {
+		if route.timeout < 0 {
+			return 0
+		}
+		return route.timeout
+	}
+	return this.Timeout
+}
+
+// serveRoute runs the route's handler h. When the route has a timeout
+// h runs on its own goroutine with a context deadline; if it is still
+// running when the deadline passes the request is answered with a 503,
+// unless the handler had started its response, and anything it writes
+// later is dropped. A later panic is reported from h's goroutine, as
+// nothing waits for it anymore
+func (this *Server) serveRoute(h http.Handler, w *responseWriter, r *http.Request, route *Route) {
+	d := this.timeout(route)
+	if d <= 0 {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	tw := &timeoutWriter{w: w, header: http.Header{}}
+	done := make(chan struct{})
+	panicked := make(chan *handlerPanic, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				stack := debug.Stack()
+				tw.lock.Lock()
+				late := tw.timedOut
+				if !late {
+					panicked <- &handlerPanic{value: err, stack: stack}
+				}
+				tw.lock.Unlock()
+				if late {
+					this.reportPanic(r, err, stack)
+				}
+			}
+		}()
+		h.ServeHTTP(tw, r)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicked:
+		// raised again on the request's goroutine for recovery,
+		// carrying the stack of the handler rather than this one
+		panic(p)
+	case <-done:
+	case <-ctx.Done():
+		tw.lock.Lock()
+		defer tw.lock.Unlock()
+		select {
+		case p := <-panicked:
+			// the handler panicked as the deadline passed
+			panic(p)
+		default:
+		}
+		tw.timedOut = true
+		if !w.started {
+			body := this.TimeoutBody
+			if len(body) == 0 {
+				body = http.StatusText(http.StatusServiceUnavailable)
+			}
+			http.Error(w, body, http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// handlerPanic is a panic of a handler run on its own goroutine
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (this *handlerPanic) String() string {
+	return fmt.Sprintf("%v\n\nhandler goroutine:\n%s", this.value, this.stack)
+}
+
+// timeoutWriter hands the handler its own header map, and passes
+// its writes on until the timeout
+type timeoutWriter struct {
+	w        http.ResponseWriter
+	header   http.Header
+	lock     sync.Mutex
+	wrote    bool
+	timedOut bool
+}
+
+func (this *timeoutWriter) Header() http.Header {
+	return this.header
+}
+
+func (this *timeoutWriter) Write(p []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !this.wrote {
+		this.writeHeader(http.StatusOK)
+	}
+	return this.w.Write(p)
+}
+
+func (this *timeoutWriter) WriteHeader(code int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.timedOut || this.wrote {
+		return
+	}
+	this.writeHeader(code)
+}
+
+func (this *timeoutWriter) writeHeader(code int) {
+	this.wrote = true
+	dst := this.w.Header()
+	for k, v := range this.header {
+		dst[k] = v
+	}
+	this.w.WriteHeader(code)
+}
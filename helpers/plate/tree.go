@@ -0,0 +1,382 @@
+package plate
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type nodeKind uint8
+
+const (
+	staticNode nodeKind = iota // literal text, matched byte for byte
+	paramNode                  // a single path segment, i.e. `:id`
+	tailNode                   // the remainder of the path, i.e. `*file` or `:file(.+)`
+)
+
+// token is a single piece of a parsed route pattern. It is
+// either a run of static text or a parameter.
+type token struct {
+	kind nodeKind
+	text string     // static text, or the parameter name
+	expr string     // the regular expression constraining a parameter
+	conv *Converter // converts the parameter to a typed value
+}
+
+// node is an entry in the compressed prefix tree used to match
+// request paths to routes. Static nodes share their common prefixes,
+// so a lookup costs time proportional to the length of the path
+// rather than the number of routes registered.
+type node struct {
+	kind     nodeKind
+	prefix   string         // static text matched by this node
+	expr     string         // parameter expression, empty for the default
+	regex    *regexp.Regexp // compiled parameter expression
+	children []*node        // static children, one per leading byte
+	params   []*node        // parameter children, in registration order
+	route    *Route
+}
+
+// router holds a prefix tree per request method. Case sensitive
+// routes are kept apart from the case insensitive ones so the
+// latter can be matched without lowercasing the request path.
+type router struct {
+	trees     map[string]*node
+	foldTrees map[string]*node
+	hosts     []*hostRouter
+	chains    map[*Route]http.Handler // the middleware chain for each route
+}
+
+// parsePattern splits a route pattern into static and parameter
+// tokens, along with the names of its parameters and the regular
+// expression equivalent to the whole pattern. Converters named in
+// the pattern, ie ‘/user/:id<int>’, are resolved with converter.
+func parsePattern(pattern string, converter func(string) (*Converter, bool)) (tokens []token, params []string, expr string, err error) {
+	parts := strings.Split(pattern, "/")
+
+	static := ""
+	regex := ""
+	for i, part := range parts {
+		if i > 0 {
+			static += "/"
+		}
+
+		//find params that start with ":", a user may choose
+		// to override the default expression similar to
+		// expressjs: ‘/user/:id([0-9]+)’. A bare expression
+		// such as ‘/(.+)’ is treated as an unnamed param
+		name, pexpr, isParam, catchAll := "", "", false, false
+		var conv *Converter
+		if strings.HasPrefix(part, ":") {
+			name, isParam = part[1:], true
+			if index := strings.Index(part, "("); index != -1 {
+				name, pexpr = part[1:index], part[index:]
+			} else if index := strings.Index(part, "<"); index != -1 && strings.HasSuffix(part, ">") {
+				var ok bool
+				name = part[1:index]
+				if conv, ok = converter(part[index+1 : len(part)-1]); !ok {
+					return nil, nil, "", fmt.Errorf("Unknown converter in %s", part)
+				}
+				pexpr = "(" + conv.Expr + ")"
+			}
+		} else if strings.HasPrefix(part, "(") {
+			pexpr, isParam = part, true
+		} else if strings.HasPrefix(part, "*") {
+			//a catch-all, ie ‘/files/*path’, matches the
+			// rest of the path, which may not be empty
+			if i != len(parts)-1 {
+				return nil, nil, "", fmt.Errorf("Catch-all %s must be the last segment", part)
+			}
+			name, isParam, catchAll = part[1:], true, true
+		}
+
+		if !isParam {
+			static += part
+			continue
+		}
+
+		if static != "" {
+			tokens = append(tokens, token{kind: staticNode, text: static})
+			regex += regexp.QuoteMeta(static)
+			static = ""
+		}
+
+		//an expression in the last segment is matched against
+		// the rest of the path, so `:file(.+)` may span slashes
+		kind := paramNode
+		if catchAll || (pexpr != "" && conv == nil && i == len(parts)-1) {
+			kind = tailNode
+		}
+		tokens = append(tokens, token{kind: kind, text: name, expr: pexpr, conv: conv})
+		params = append(params, name)
+
+		if catchAll {
+			regex += "(.+)"
+		} else if pexpr == "" {
+			regex += "([^/]+)"
+		} else {
+			regex += pexpr
+		}
+	}
+
+	if static != "" {
+		tokens = append(tokens, token{kind: staticNode, text: static})
+		regex += regexp.QuoteMeta(static)
+	}
+
+	return tokens, params, "^" + regex + "$", nil
+}
+
+func newRouter(routes []*Route) *router {
+	rt := &router{
+		trees:     make(map[string]*node),
+		foldTrees: make(map[string]*node),
+	}
+	for _, route := range routes {
+		rt.scope(route.host).add(route)
+	}
+	return rt
+}
+
+// add inserts the route into the tree for its method. When two
+// routes resolve to the same node, the first one registered wins.
+func (this *router) add(route *Route) {
+	trees, fold := this.trees, !route.sensitive
+	if fold {
+		trees = this.foldTrees
+	}
+
+	root := trees[route.method]
+	if root == nil {
+		root = &node{}
+		trees[route.method] = root
+	}
+
+	n := root
+	for _, tok := range route.tokens {
+		if tok.kind == staticNode {
+			text := tok.text
+			if fold {
+				text = lowerASCII(text)
+			}
+			n = n.addStatic(text)
+			continue
+		}
+		n = n.addParam(tok.kind, tok.expr, fold)
+	}
+
+	if n.route == nil {
+		n.route = route
+	}
+}
+
+// lookup finds the route registered for method that matches path,
+// along with the values of its parameters. Routes registered for
+// ANY method are tried last.
+func (this *router) lookup(method, path string) (*Route, []string) {
+	for _, m := range []string{method, ANY} {
+		if root := this.trees[m]; root != nil {
+			if route, values := root.lookup(path, false, nil); route != nil {
+				return route, values
+			}
+		}
+		if root := this.foldTrees[m]; root != nil {
+			if route, values := root.lookup(path, true, nil); route != nil {
+				return route, values
+			}
+		}
+	}
+	return nil, nil
+}
+
+// allowed returns the methods with a route matching host and path,
+// for the Allow header. HEAD is implied by GET, and OPTIONS is
+// always answered once any method matches.
+func (this *router) allowed(host, path string) []string {
+	found := map[string]bool{}
+	for _, s := range this.scopes(host) {
+		for _, trees := range []map[string]*node{s.trees, s.foldTrees} {
+			for method := range trees {
+				if method == ANY {
+					continue
+				}
+				if route, _ := s.lookup(method, path); route != nil {
+					found[method] = true
+				}
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	if found[GET] {
+		found[HEAD] = true
+	}
+	found[OPTIONS] = true
+
+	methods := make([]string, 0, len(found))
+	for method := range found {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// addStatic walks the static text s down from n, splitting nodes
+// where s diverges from an existing prefix, and returns the node at
+// which s ends.
+func (n *node) addStatic(s string) *node {
+	for len(s) > 0 {
+		var child *node
+		for _, c := range n.children {
+			if c.prefix[0] == s[0] {
+				child = c
+				break
+			}
+		}
+
+		if child == nil {
+			child = &node{kind: staticNode, prefix: s}
+			n.children = append(n.children, child)
+			return child
+		}
+
+		l := commonPrefix(s, child.prefix)
+		if l < len(child.prefix) {
+			split := &node{
+				kind:     staticNode,
+				prefix:   child.prefix[l:],
+				children: child.children,
+				params:   child.params,
+				route:    child.route,
+			}
+			child.prefix = child.prefix[:l]
+			child.children = []*node{split}
+			child.params = nil
+			child.route = nil
+		}
+
+		s = s[l:]
+		n = child
+	}
+	return n
+}
+
+// addParam returns the parameter child of n with the given kind and
+// expression, creating it if this is the first route to use it.
+func (n *node) addParam(kind nodeKind, expr string, fold bool) *node {
+	for _, c := range n.params {
+		if c.kind == kind && c.expr == expr {
+			return c
+		}
+	}
+
+	child := &node{kind: kind, expr: expr}
+	if expr != "" {
+		flags := ""
+		if fold {
+			flags = "(?i)"
+		}
+		child.regex = regexp.MustCompile(flags + "^" + expr + "$")
+	}
+	n.params = append(n.params, child)
+	return child
+}
+
+// lookup matches path against the subtree below n. Static children
+// are preferred over parameters, and parameters are tried in the
+// order they were registered, backtracking when a branch fails.
+func (n *node) lookup(path string, fold bool, values []string) (*Route, []string) {
+	if len(path) == 0 && n.route != nil {
+		return n.route, values
+	}
+
+	if len(path) > 0 {
+		for _, c := range n.children {
+			if !hasPrefix(path, c.prefix, fold) {
+				continue
+			}
+			if route, vals := c.lookup(path[len(c.prefix):], fold, values); route != nil {
+				return route, vals
+			}
+			//only one static child can share the leading byte
+			break
+		}
+	}
+
+	for _, c := range n.params {
+		if c.kind == tailNode {
+			//a catch-all has no expression, it takes
+			// whatever is left of the path
+			matched := len(path) > 0
+			if c.regex != nil {
+				matched = c.regex.MatchString(path)
+			}
+			if c.route != nil && matched {
+				return c.route, append(values, path)
+			}
+			continue
+		}
+
+		end := strings.IndexByte(path, '/')
+		if end == -1 {
+			end = len(path)
+		}
+		if end == 0 {
+			continue
+		}
+
+		segment := path[:end]
+		if c.regex != nil && !c.regex.MatchString(segment) {
+			continue
+		}
+		if route, vals := c.lookup(path[end:], fold, append(values, segment)); route != nil {
+			return route, vals
+		}
+	}
+
+	return nil, nil
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// hasPrefix reports whether s begins with prefix. When fold is set
+// the comparison ignores ASCII case, prefix is expected to already
+// be lowercase.
+func hasPrefix(s, prefix string, fold bool) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	if !fold {
+		return s[:len(prefix)] == prefix
+	}
+	for i := 0; i < len(prefix); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func lowerASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
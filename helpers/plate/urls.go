@@ -0,0 +1,120 @@
+package plate
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Name assigns a name to the route, so URLs can be built
+// from it with Server.URL or the `url` template func.
+func (this *Route) Name(name string) *Route {
+	this.name = name
+
+	if this.server != nil {
+		this.server.lock.Lock()
+		if this.server.names == nil {
+			this.server.names = map[string]*Route{}
+		}
+		this.server.names[name] = this
+		this.server.lock.Unlock()
+	}
+
+	return this
+}
+
+// URL builds the path for the route registered under name,
+// filling in its parameters from the name/value pairs given:
+//
+//	server.URL("user.show", "id", 42) // "/user/42"
+//
+// Values must satisfy any expression constraining the
+// parameter, ie ‘/user/:id([0-9]+)’. Only routes of this
+// server are considered, not those of mounted handlers.
+func (this *Server) URL(name string, params ...interface{}) (string, error) {
+	this.lock.RLock()
+	route, ok := this.names[name]
+	this.lock.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("No route named %s", name)
+	}
+	return route.URL(params...)
+}
+
+// URL builds the path for the route, filling in its
+// parameters from the name/value pairs given.
+func (this *Route) URL(params ...interface{}) (string, error) {
+	if this.regex == nil {
+		return "", fmt.Errorf("Route %s failed to register", this.pattern)
+	}
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("Odd number of URL params for %s", this.pattern)
+	}
+
+	values := make(map[string]interface{}, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		key, ok := params[i].(string)
+		if !ok {
+			return "", fmt.Errorf("URL param name %v is not a string", params[i])
+		}
+		values[strings.TrimPrefix(key, ":")] = params[i+1]
+	}
+
+	var buf strings.Builder
+	for _, tok := range this.tokens {
+		if tok.kind == staticNode {
+			buf.WriteString(tok.text)
+			continue
+		}
+
+		v, ok := values[tok.text]
+		if !ok || len(tok.text) == 0 {
+			return "", fmt.Errorf("Missing URL param %s for %s", tok.text, this.pattern)
+		}
+		value := fmt.Sprint(v)
+		if tok.conv != nil && tok.conv.Format != nil {
+			value = tok.conv.Format(v)
+		}
+		if err := tok.validate(value); err != nil {
+			return "", err
+		}
+
+		if tok.kind == tailNode {
+			//keep the slashes in a value spanning segments
+			parts := strings.Split(value, "/")
+			for i, part := range parts {
+				parts[i] = url.PathEscape(part)
+			}
+			buf.WriteString(strings.Join(parts, "/"))
+			continue
+		}
+		buf.WriteString(url.PathEscape(value))
+	}
+
+	return buf.String(), nil
+}
+
+// validate checks value against the expression constraining
+// the parameter.
+func (this token) validate(value string) error {
+	if this.kind == tailNode && this.expr == "" && len(value) == 0 {
+		return fmt.Errorf("Empty value for URL param %s", this.text)
+	}
+	if this.kind == paramNode && (len(value) == 0 || strings.Contains(value, "/")) {
+		return fmt.Errorf("Invalid value %q for URL param %s", value, this.text)
+	}
+	if this.expr == "" {
+		return nil
+	}
+
+	regex, err := regexp.Compile("^" + this.expr + "$")
+	if err != nil {
+		return err
+	}
+	if !regex.MatchString(value) {
+		return fmt.Errorf("Value %q for URL param %s does not match %s", value, this.text, this.expr)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package plate
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// RouteErrors lists every problem found with a server's routes
+type RouteErrors []error
+
+func (this RouteErrors) Error() string {
+	msgs := make([]string, len(this))
+	for i, err := range this {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d route errors:\n\t%s", len(this), strings.Join(msgs, "\n\t"))
+}
+
+// Validate reports every problem with the route table at once: patterns
+// that failed to register while Validating, names given to more than one
+// route, and routes that can never be matched because an earlier route
+// always wins, ie ‘/user/:id’ registered before ‘/user/:id([0-9]+)’.
+//
+// Static segments always take precedence over params, so ‘/user/new’
+// is reachable no matter where it is registered relative to ‘/user/:id’.
+func (this *Server) Validate() error {
+	errs := RouteErrors{}
+	errs = append(errs, this.errors...)
+
+	names := map[string]*Route{}
+	for _, route := range this.Routes {
+		if len(route.name) == 0 {
+			continue
+		}
+		if other, ok := names[route.name]; ok {
+			errs = append(errs, fmt.Errorf("%s %s: name %s is already used by %s %s",
+				route.method, route.pattern, route.name, other.method, other.pattern))
+			continue
+		}
+		names[route.name] = route
+	}
+
+	rt := this.routes()
+	for _, route := range this.Routes {
+		path, ok := route.sample()
+		if !ok {
+			continue
+		}
+		if match, _ := rt.scope(route.host).lookup(route.method, path); match != route {
+			if match == nil {
+				errs = append(errs, fmt.Errorf("%s %s: unreachable", route.method, route.pattern))
+				continue
+			}
+			errs = append(errs, fmt.Errorf("%s %s: unreachable, %s is matched by %s",
+				route.method, route.pattern, path, match.pattern))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// sample builds a path the route should match, choosing a
+// value for each param from its expression. It reports false if
+// no value could be chosen.
+func (this *Route) sample() (string, bool) {
+	var buf strings.Builder
+	for _, tok := range this.tokens {
+		if tok.kind == staticNode {
+			buf.WriteString(tok.text)
+			continue
+		}
+		if tok.expr == "" {
+			buf.WriteString("~")
+			continue
+		}
+		re, err := syntax.Parse(tok.expr, syntax.Perl)
+		if err != nil {
+			return "", false
+		}
+		value := sampleRegexp(re.Simplify())
+		if tok.conv != nil {
+			if _, err := tok.conv.Convert(value); err != nil {
+				return "", false
+			}
+		}
+		buf.WriteString(value)
+	}
+	return buf.String(), true
+}
+
+// sampleRegexp returns a short string matched by re, preferring
+// characters that are unlikely to collide with static routes
+func sampleRegexp(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCharClass:
+		return string(sampleRune(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return "~"
+	case syntax.OpCapture, syntax.OpPlus:
+		return sampleRegexp(re.Sub[0])
+	case syntax.OpRepeat:
+		return strings.Repeat(sampleRegexp(re.Sub[0]), re.Min)
+	case syntax.OpConcat:
+		s := ""
+		for _, sub := range re.Sub {
+			s += sampleRegexp(sub)
+		}
+		return s
+	case syntax.OpAlternate:
+		return sampleRegexp(re.Sub[0])
+	}
+	return ""
+}
+
+// sampleRune picks a rune from a character class, given as pairs
+// of inclusive ranges, preferring a letter and then a digit
+func sampleRune(ranges []rune) rune {
+	if len(ranges) == 0 {
+		return '~'
+	}
+	for _, is := range []func(rune) bool{unicode.IsLetter, unicode.IsDigit} {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			for r := ranges[i]; r <= ranges[i+1] && r < 128; r++ {
+				if is(r) {
+					return r
+				}
+			}
+		}
+	}
+	return ranges[0]
+}
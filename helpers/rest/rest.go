@@ -1,13 +1,28 @@
 package rest
 
 import (
+	"../plate"
 	"io/ioutil"
 	"net/http"
 )
 
+// Get fetches url. When r, the request being served, is given its
+// request id is forwarded so the call can be correlated with it, and
+// the call is abandoned once r is cancelled or its deadline passes
 func Get(url string, r *http.Request) (buf []byte, err error) {
 
-	res, err := http.Get(url)
+	req, err := http.NewRequest(plate.GET, url, nil)
+	if err != nil {
+		return
+	}
+	if r != nil {
+		req = req.WithContext(r.Context())
+		if id := plate.RequestID(r); len(id) > 0 {
+			req.Header.Set(plate.RequestIDHeader, id)
+		}
+	}
+
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return
 	}
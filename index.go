@@ -12,9 +12,10 @@ import (
 )
 
 var (
-	CorsHandler = func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Access-Control-Allow-Origin", "*")
-		return
+	CorsOptions = plate.CorsOptions{
+		Origins: []string{"*"},
+		Methods: []string{plate.GET, plate.HEAD, plate.POST, plate.PUT, plate.PATCH, plate.DELETE},
+		Headers: []string{"*"},
 	}
 )
 
@@ -30,8 +31,10 @@ func main() {
 
 	globals.SetGlobals()
 	server := plate.NewServer("doughboy")
+	server.Validating = true
+	server.Config = &plate.ServerConfig{RecoverPanic: true}
 
-	server.AddFilter(CorsHandler)
+	server.Use(plate.Cors(CorsOptions))
 
 	server.Get("/", controllers.Index)
 
@@ -39,6 +42,10 @@ func main() {
 
 	server.Static("/", dir+"/"+"static")
 
+	if err := server.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
 	http.Handle("/", server)
 
 	log.Println("Server running on port " + *globals.ListenAddr)
@@ -79,7 +79,7 @@ func TestHandler(t *testing.T) {
 	server := plate.NewServer("doughboy")
 	server.Logging = true
 
-	server.AddFilter(CorsHandler)
+	server.Use(plate.Cors(CorsOptions))
 
 	server.Get("/", controllers.Index)
 